@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// maxAggregatePatches is how many patches a single matchup query may combine.
+const maxAggregatePatches = 30
+
+// patchSelection picks the patches a matchup query combines: either the
+// newest count patches, or every patch since a given one, in both cases up to
+// the served patch.
+type patchSelection struct {
+	count     int
+	since     *PatchVersion
+	breakdown bool
+}
+
+// parsePatchSelection reads ?patches=N or ?since=14.1, and ?breakdown=true,
+// from the request. It reports false when the request asks for the served
+// patch alone.
+func parsePatchSelection(c *gin.Context) (patchSelection, bool, error) {
+	var sel patchSelection
+	count, since := c.Query("patches"), c.Query("since")
+	if count != "" && since != "" {
+		return sel, false, fmt.Errorf("patches and since cannot be used together")
+	}
+
+	if count != "" {
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 || n > maxAggregatePatches {
+			return sel, false, fmt.Errorf("patches must be between 1 and %d", maxAggregatePatches)
+		}
+		sel.count = n
+	}
+	if since != "" {
+		v, err := ParsePatchVersion(since)
+		if err != nil {
+			return sel, false, fmt.Errorf("since must be a patch such as 14.1")
+		}
+		sel.since = &v
+	}
+	if b := c.Query("breakdown"); b != "" {
+		breakdown, err := strconv.ParseBool(b)
+		if err != nil {
+			return sel, false, fmt.Errorf("breakdown must be true or false")
+		}
+		sel.breakdown = breakdown
+	}
+
+	if sel.count == 0 && sel.since == nil {
+		if sel.breakdown {
+			return sel, false, fmt.Errorf("breakdown requires patches or since")
+		}
+		return sel, false, nil
+	}
+	return sel, true, nil
+}
+
+// SelectPatches returns the patches that sel combines, newest first: those
+// with matchups that are no newer than served. After a rollback this leaves
+// out the rolled-back patch.
+func (db *DB) SelectPatches(ctx context.Context, served string, sel patchSelection) ([]string, error) {
+	defer observeQuery("SelectPatches", time.Now())
+
+	top, err := ParsePatchVersion(served)
+	if err != nil {
+		return nil, err
+	}
+
+	limit := maxAggregatePatches
+	if sel.count > 0 {
+		limit = sel.count
+	}
+	var sinceMajor, sinceMinor sql.NullInt64
+	if sel.since != nil {
+		sinceMajor = sql.NullInt64{Int64: int64(sel.since.Major), Valid: true}
+		sinceMinor = sql.NullInt64{Int64: int64(sel.since.Minor), Valid: true}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.version
+		FROM patches p
+		WHERE (p.major, p.minor) <= ($1, $2)
+			AND ($3::INT IS NULL OR (p.major, p.minor) >= ($3, $4))
+			AND EXISTS (SELECT 1 FROM matchups WHERE patch = p.version)
+		ORDER BY p.major DESC, p.minor DESC
+		LIMIT $5
+	`, top.Major, top.Minor, sinceMajor, sinceMinor, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patches := []string{}
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		patches = append(patches, version)
+	}
+
+	return patches, rows.Err()
+}
+
+// GetAggregatedMatchups returns the matchups of a champion in a role combined
+// over patches, with win rates weighted by sample size. A limit of 0 returns
+// every matchup. With breakdown set, each matchup also lists its per-patch
+// numbers.
+func (db *DB) GetAggregatedMatchups(ctx context.Context, champName string, role string, patches []string, limit int, breakdown bool) ([]Matchup, error) {
+	defer observeQuery("GetAggregatedMatchups", time.Now())
+
+	var sqlLimit interface{}
+	if limit > 0 {
+		sqlLimit = limit
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.name, SUM(m.win_rate * m.sample_size) / SUM(m.sample_size), SUM(m.sample_size), MAX(m.scraped_at)
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE LOWER(champ.name) = LOWER($1) AND LOWER(m.role) = LOWER($2) AND m.patch = ANY($3)
+		GROUP BY c.name
+		HAVING SUM(m.sample_size) > 0
+		ORDER BY 2 DESC, c.name
+		LIMIT $4
+	`, champName, role, pq.Array(patches), sqlLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matchups []Matchup
+	index := make(map[string]int)
+	for rows.Next() {
+		var m Matchup
+		var winRate float64
+		var sampleSize int64
+		if err := rows.Scan(&m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.FormatInt(sampleSize, 10)
+		index[m.Champion] = len(matchups)
+		matchups = append(matchups, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !breakdown || len(matchups) == 0 {
+		return matchups, nil
+	}
+
+	rows, err = db.QueryContext(ctx, `
+		SELECT c.name, m.patch, m.win_rate, m.sample_size
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		JOIN patches p ON m.patch = p.version
+		WHERE LOWER(champ.name) = LOWER($1) AND LOWER(m.role) = LOWER($2) AND m.patch = ANY($3)
+		ORDER BY p.major DESC, p.minor DESC
+	`, champName, role, pq.Array(patches))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		var pm PatchMatchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&name, &pm.Patch, &winRate, &sampleSize); err != nil {
+			return nil, err
+		}
+		i, ok := index[name]
+		if !ok {
+			// Cut by the limit.
+			continue
+		}
+		pm.WinRate = fmt.Sprintf("%.2f", winRate)
+		pm.SampleSize = strconv.Itoa(sampleSize)
+		matchups[i].Patches = append(matchups[i].Patches, pm)
+	}
+
+	return matchups, rows.Err()
+}
+
+// serveAggregated answers a matchup request that combines several patches.
+func serveAggregated(c *gin.Context, db *DB, champion string, role string, limit int, served string, sel patchSelection) {
+	ctx := c.Request.Context()
+	logger := loggerFrom(ctx)
+
+	if served == "" {
+		c.JSON(404, gin.H{"error": "No patches found"})
+		return
+	}
+
+	patches, err := db.SelectPatches(ctx, served, sel)
+	if err != nil {
+		logger.Error("Error selecting patches", "error", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(patches) == 0 {
+		c.JSON(404, gin.H{"error": "No patches found", "patch": served})
+		return
+	}
+
+	matchups, err := db.GetAggregatedMatchups(ctx, champion, role, patches, limit, sel.breakdown)
+	if err != nil {
+		logger.Error("Error getting aggregated matchups", "error", err)
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(matchups) == 0 {
+		c.JSON(404, gin.H{"error": "No matchups found", "patch": served, "patches": patches})
+		return
+	}
+
+	logger.Debug("Returning aggregated matchups", "count", len(matchups), "patches", len(patches))
+	c.JSON(200, gin.H{"patch": served, "patches": patches, "matchups": matchups})
+}
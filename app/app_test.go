@@ -0,0 +1,1936 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/parquet-go/parquet-go"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := []byte("port: 9090\nschedules:\n  refresh: \"@every 12h\"\n  cleanup: \"off\"\nroles: [top, mid]\nopgg_base_url: https://example.com/\n")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DATABASE_URL", "postgres://localhost/test")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://pickhelper.lol, http://localhost:3000")
+	t.Setenv("SCHEDULE_FULL_SCRAPE", "0 * * * *")
+	t.Setenv("PROMOTION_MIN_MEDIAN_SAMPLES", "250")
+
+	cfg, err := LoadConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 9090, cfg.Port)
+	assert.Equal(t, map[string]string{
+		"patch-check": "*/30 * * * *",
+		"full-scrape": "0 * * * *",
+		"refresh":     "@every 12h",
+		"cleanup":     "off",
+		"retention":   "0 4 * * 0",
+	}, cfg.Schedules)
+	assert.Equal(t, 250, cfg.PromotionMinMedianSamples)
+	assert.Equal(t, []string{"top", "mid"}, cfg.Roles)
+	assert.Equal(t, []string{"https://pickhelper.lol", "http://localhost:3000"}, cfg.AllowedOrigins)
+	assert.Equal(t, "https://example.com", cfg.OpGGBaseURL)
+
+	t.Setenv("RETAIN_PATCHES", "1")
+	_, err = LoadConfig()
+	assert.Error(t, err)
+
+	t.Setenv("RETAIN_PATCHES", "")
+	t.Setenv("SCHEDULE_PATCH_CHECK", "every half hour")
+	_, err = LoadConfig()
+	assert.Error(t, err)
+
+	t.Setenv("SCHEDULE_PATCH_CHECK", "")
+	t.Setenv("PORT", "0")
+	_, err = LoadConfig()
+	assert.Error(t, err)
+}
+
+func TestNewDB(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mock.ExpectPing()
+
+	testDB, err := NewDB("sqlmock_db")
+	assert.NoError(t, err)
+	assert.NotNil(t, testDB)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSavePatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectExec("INSERT INTO patches").WithArgs("13.10", 13, 10, 13).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = testDB.SavePatch(context.Background(), PatchInfo{Version: "13.10"})
+	assert.NoError(t, err)
+
+	err = testDB.SavePatch(context.Background(), PatchInfo{Version: ""})
+	assert.Error(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchVersion(t *testing.T) {
+	for input, want := range map[string]PatchVersion{
+		"14.10":            {14, 10},
+		"Version: 14.10":   {14, 10},
+		" Version 9.24 ":   {9, 24},
+		"14.10.1":          {14, 10},
+		"25.S1.2":          {25, 2},
+		"Version: 25.S1.3": {25, 3},
+	} {
+		v, err := ParsePatchVersion(input)
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, v, input)
+	}
+	for _, input := range []string{"", "Version: ", "14", "latest", "14.x"} {
+		_, err := ParsePatchVersion(input)
+		assert.Error(t, err, input)
+	}
+
+	assert.True(t, PatchVersion{14, 10}.After(PatchVersion{14, 9}))
+	assert.True(t, PatchVersion{14, 1}.After(PatchVersion{9, 24}))
+	assert.False(t, PatchVersion{13, 24}.After(PatchVersion{13, 24}))
+	assert.Equal(t, -1, PatchVersion{25, 1}.Compare(PatchVersion{25, 4}))
+	assert.Equal(t, "25.2", PatchVersion{25, 2}.String())
+	assert.Equal(t, 25, PatchVersion{25, 2}.Season())
+}
+
+func TestUpdateScrapingStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectExec("INSERT INTO scraping_status").WithArgs("13.10", "13.9", true).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = testDB.UpdateScrapingStatus(context.Background(), ScrapingStatus{CurrentPatch: "13.10", LastScrapedPatch: "13.9", IsUpdating: true})
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetCurrentPatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	rows := sqlmock.NewRows([]string{"version"}).AddRow("13.10")
+	mock.ExpectQuery("SELECT version FROM patches ORDER BY major DESC NULLS LAST, minor DESC NULLS LAST").WillReturnRows(rows)
+
+	patch, err := testDB.GetCurrentPatch(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "13.10", patch.Version)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSaveChampion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectExec("INSERT INTO champions").WithArgs("Ahri", "http://example.com/ahri.png").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	err = testDB.SaveChampion(context.Background(), Champion{Name: "Ahri", AvatarURL: "http://example.com/ahri.png"})
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSaveMatchups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Zed", "mid", 48.5, 1000, "13.10", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	matchups := []Matchup{
+		{Champion: "Zed", WinRate: "48.5", SampleSize: "1000"},
+	}
+
+	err = testDB.SaveMatchups(context.Background(), "Ahri", "mid", matchups, "13.10")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetTopMatchups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	scrapedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	rows := sqlmock.NewRows([]string{"name", "win_rate", "sample_size", "scraped_at"}).
+		AddRow("Zed", 48.5, 1000, scrapedAt).
+		AddRow("Yasuo", 51.2, 800, scrapedAt)
+
+	mock.ExpectQuery("SELECT c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").WithArgs("Ahri", "mid", "13.10", 2).WillReturnRows(rows)
+
+	matchups, err := testDB.GetTopMatchups(context.Background(), "Ahri", "mid", 2, "13.10")
+	assert.NoError(t, err)
+	assert.Len(t, matchups, 2)
+	assert.Equal(t, "Zed", matchups[0].Champion)
+	assert.Equal(t, "48.50", matchups[0].WinRate)
+	assert.Equal(t, "1000", matchups[0].SampleSize)
+	assert.Equal(t, scrapedAt, matchups[0].ScrapedAt)
+
+	// A limit of 0 returns every matchup.
+	mock.ExpectQuery("SELECT c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").WithArgs("Ahri", "mid", "13.10", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "win_rate", "sample_size", "scraped_at"}))
+	_, err = testDB.GetTopMatchups(context.Background(), "Ahri", "mid", 0, "13.10")
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMatchupsLimit(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	for _, limit := range []string{"-1", "abc", "2.5"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/matchups/Ahri/mid?limit="+limit, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, limit)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("POST", "/matchups/batch?limit="+limit, strings.NewReader(`{"Lookups":[{"Champion":"Ahri","Role":"mid"}]}`))
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, limit)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetMatchupsBatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	rows := sqlmock.NewRows([]string{"name", "role", "name", "win_rate", "sample_size", "scraped_at"}).
+		AddRow("Ahri", "mid", "Yasuo", 51.2, 800, time.Now()).
+		AddRow("Ahri", "mid", "Zed", 48.5, 1000, time.Now()).
+		AddRow("Darius", "top", "Garen", 50.1, 1500, time.Now())
+
+	// Only the requested pairs are fetched, each once.
+	mock.ExpectQuery("SELECT champ.name, m.role, c.name, m.win_rate, m.sample_size, m.scraped_at FROM unnest\\(\\$1::text\\[\\], \\$2::text\\[\\]\\) AS l\\(champion, role\\)").
+		WithArgs(pq.Array([]string{"ahri", "darius"}), pq.Array([]string{"mid", "top"}), "13.10").
+		WillReturnRows(rows)
+
+	lookups := []MatchupLookup{
+		{Champion: "Ahri", Role: "mid"},
+		{Champion: "darius", Role: "top", Opponent: "garen"},
+		{Champion: "Ahri", Role: "mid", Opponent: "Zed"},
+	}
+
+	results, err := testDB.GetMatchupsBatch(context.Background(), lookups, 8, "13.10")
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Len(t, results[0].Matchups, 2)
+	assert.Equal(t, "Yasuo", results[0].Matchups[0].Champion)
+	assert.Len(t, results[1].Matchups, 1)
+	assert.Equal(t, "Garen", results[1].Matchups[0].Champion)
+	assert.Equal(t, "1500", results[1].Matchups[0].SampleSize)
+	assert.Len(t, results[2].Matchups, 1)
+	assert.Equal(t, "48.50", results[2].Matchups[0].WinRate)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBatchEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	post := func(r *gin.Engine, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/matchups/batch", strings.NewReader(body))
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	tooMany := `{"Lookups":[` + strings.Repeat(`{"Champion":"Ahri","Role":"mid"},`, maxBatchLookups) + `{"Champion":"Zed","Role":"mid"}]}`
+	for _, body := range []string{
+		"",
+		`{"Lookups":[]}`,
+		tooMany,
+		`{"Lookups":[{"Champion":"Ahri"}]}`,
+		`{"Lookups":[{"Champion":"Ahri","Role":"middle"}]}`,
+	} {
+		assert.Equal(t, 400, post(r, body).Code, body)
+	}
+
+	expectStatus := func() {
+		mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+			WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("13.10", "13.10", false, nil))
+	}
+	scrapedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	body := `{"Lookups":[{"Champion":"Ahri","Role":"mid"},{"Champion":"ahri","Role":"MID","Opponent":"zed"}]}`
+	var resp struct {
+		Patch   string
+		Results []MatchupLookupResult
+	}
+	assertResults := func(w *httptest.ResponseRecorder) {
+		assert.Equal(t, 200, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "13.10", resp.Patch)
+		assert.Len(t, resp.Results, 2)
+		assert.Len(t, resp.Results[0].Matchups, 2)
+		assert.Equal(t, []Matchup{{Champion: "Zed", WinRate: "48.50", SampleSize: "1000", ScrapedAt: scrapedAt}}, resp.Results[1].Matchups)
+	}
+
+	// From the database.
+	expectStatus()
+	mock.ExpectQuery("FROM unnest").WithArgs(pq.Array([]string{"ahri"}), pq.Array([]string{"mid"}), "13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "role", "name", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Ahri", "mid", "Yasuo", 53.1, 900, scrapedAt).
+			AddRow("Ahri", "mid", "Zed", 48.5, 1000, scrapedAt))
+	assertResults(post(r, body))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+
+	// From the snapshot, once it has been built in the background. The
+	// build runs alongside the first request, so queries may come in any
+	// order.
+	cfg.Snapshot = true
+	r = NewRouter(testDB, cfg)
+	mock.MatchExpectationsInOrder(false)
+	expectStatus()
+	mock.ExpectQuery("FROM unnest").WithArgs(pq.Array([]string{"ahri"}), pq.Array([]string{"mid"}), "13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "role", "name", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Ahri", "mid", "Yasuo", 53.1, 900, scrapedAt).
+			AddRow("Ahri", "mid", "Zed", 48.5, 1000, scrapedAt))
+	mock.ExpectQuery("SELECT name, avatar_url FROM champions").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Ahri", "http://example.com/ahri.png"))
+	mock.ExpectQuery("FROM matchups m JOIN champions c").WithArgs("13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"champion", "role", "opponent", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Ahri", "mid", "Yasuo", 53.1, 900, scrapedAt).
+			AddRow("Ahri", "mid", "Zed", 48.5, 1000, scrapedAt))
+	assertResults(post(r, body))
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond)
+
+	// Only the status is queried now.
+	expectStatus()
+	assertResults(post(r, body))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetHeadToHead(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	rows := sqlmock.NewRows([]string{"name", "name", "win_rate", "sample_size", "scraped_at"}).
+		AddRow("Darius", "Garen", 53.1, 1200, time.Now()).
+		AddRow("Garen", "Darius", 43.4, 1100, time.Now())
+
+	mock.ExpectQuery("SELECT champ.name, c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").
+		WithArgs("darius", "garen", "top", "13.10").
+		WillReturnRows(rows)
+
+	h2h, err := testDB.GetHeadToHead(context.Background(), "darius", "garen", "top", "13.10", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "Darius", h2h.Champion)
+	assert.Equal(t, "Garen", h2h.Opponent)
+	assert.Equal(t, "53.10", h2h.ChampionView.WinRate)
+	assert.Equal(t, "43.40", h2h.OpponentView.WinRate)
+	assert.Equal(t, "3.50", h2h.Discrepancy)
+	assert.True(t, h2h.Disagree)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestHeadToHeadEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	expectStatus := func() {
+		mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+			WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("13.10", "13.10", false, nil))
+	}
+	expectViews := func(opponent string, rows *sqlmock.Rows) {
+		mock.ExpectQuery("SELECT champ.name, c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").
+			WithArgs("darius", opponent, "top", "13.10").WillReturnRows(rows)
+	}
+	views := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "name", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Darius", "Garen", 53.1, 1200, time.Now()).
+			AddRow("Garen", "Darius", 46.2, 1100, time.Now())
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	var resp struct {
+		Patch   string
+		Matchup HeadToHead
+	}
+
+	expectStatus()
+	expectViews("garen", views())
+	w := get("/matchups/darius/top/vs/garen")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "0.70", resp.Matchup.Discrepancy)
+	assert.False(t, resp.Matchup.Disagree)
+
+	// Served from the cache while the patch is unchanged.
+	expectStatus()
+	w = get("/matchups/darius/top/vs/garen")
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+
+	// Below the threshold the perspectives disagree.
+	expectStatus()
+	expectViews("garen", views())
+	w = get("/matchups/darius/top/vs/garen?threshold=0.5")
+	assert.Equal(t, 200, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.True(t, resp.Matchup.Disagree)
+
+	expectStatus()
+	w = get("/matchups/darius/top/vs/garen?threshold=-1")
+	assert.Equal(t, 400, w.Code)
+
+	expectStatus()
+	w = get("/matchups/darius/top/vs/Darius")
+	assert.Equal(t, 400, w.Code)
+
+	expectStatus()
+	expectViews("nobody", sqlmock.NewRows([]string{"name", "name", "win_rate", "sample_size", "scraped_at"}))
+	w = get("/matchups/darius/top/vs/nobody")
+	assert.Equal(t, 404, w.Code)
+	assert.Contains(t, w.Body.String(), `"patch":"13.10"`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestGetAllChampions(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	rows := sqlmock.NewRows([]string{"name", "avatar_url"}).
+		AddRow("Ahri", "http://example.com/ahri.png").
+		AddRow("Zed", "http://example.com/zed.png")
+
+	mock.ExpectQuery("SELECT name, avatar_url FROM champions").WillReturnRows(rows)
+
+	champions, err := testDB.GetAllChampions(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, champions, 2)
+	assert.Equal(t, "Ahri", champions[0].Name)
+	assert.Equal(t, "http://example.com/ahri.png", champions[0].AvatarURL)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestTryAdvisoryLock(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(scraperLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(scraperLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(scraperLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(false))
+
+	lock, err := testDB.TryAdvisoryLock(scraperLockKey)
+	assert.NoError(t, err)
+	assert.NotNil(t, lock)
+	assert.NoError(t, lock.Release())
+
+	lock, err = testDB.TryAdvisoryLock(scraperLockKey)
+	assert.NoError(t, err)
+	assert.Nil(t, lock)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAcquireLease(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectQuery("SELECT pg_try_advisory_lock").WithArgs(scraperLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_lock"}).AddRow(true))
+	mock.ExpectExec("INSERT INTO scraper_leader").WithArgs("host-1").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE scraper_leader SET renewed_at").WithArgs("host-1").WillReturnError(sql.ErrConnDone)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(scraperLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	lease, err := testDB.AcquireLease(scraperLockKey, "host-1", 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.NotNil(t, lease)
+
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatal("expected the lease to be lost after a failed renewal")
+	}
+	assert.NoError(t, lease.Release())
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, len(migrations), 2)
+	for i, m := range migrations {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+	assert.Equal(t, "initial_schema", migrations[0].Name)
+}
+
+func TestMigrate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+	for _, m := range migrations[1:] {
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(m.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(m.Version, m.Name).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectCommit()
+	}
+	mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	err = testDB.Migrate()
+	assert.NoError(t, err)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	r := gin.New()
+	r.Use(metricsMiddleware())
+	r.GET("/ping/:id", func(c *gin.Context) { c.Status(204) })
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("/ping/:id", "GET", "204"))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping/1", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 204, w.Code)
+	assert.Equal(t, before+1, testutil.ToFloat64(httpRequests.WithLabelValues("/ping/:id", "GET", "204")))
+
+	recordScrapingStatus(ScrapingStatus{CurrentPatch: "13.11", LastScrapedPatch: "13.10", IsUpdating: true})
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `pickhelper_http_requests_total{method="GET",route="/ping/:id",status="204"}`)
+	assert.Contains(t, w.Body.String(), `pickhelper_served_patch{patch="13.10"} 1`)
+	assert.Contains(t, w.Body.String(), "pickhelper_is_updating 1")
+}
+
+func TestRequestLogger(t *testing.T) {
+	r := gin.New()
+	r.Use(requestLogger())
+	r.GET("/ping", func(c *gin.Context) {
+		assert.NotSame(t, slog.Default(), loggerFrom(c.Request.Context()))
+		c.Status(204)
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ping", nil)
+	r.ServeHTTP(w, req)
+	assert.Len(t, w.Header().Get("X-Request-ID"), 16)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/ping", nil)
+	req.Header.Set("X-Request-ID", "abc-123")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "abc-123", w.Header().Get("X-Request-ID"))
+}
+
+func TestReadyzEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	r := NewRouter(testDB, DefaultConfig())
+	cfg := DefaultConfig()
+	cfg.RunsScraper = true
+	withScraper := NewRouter(testDB, cfg)
+
+	leaderSince := time.Now().Add(-3 * time.Hour)
+	noJobs := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"name", "last_started_at", "last_finished_at", "last_error", "last_duration_ms"})
+	}
+	expect := func(lastScraped interface{}, renewedAt time.Time, jobs *sqlmock.Rows) {
+		mock.ExpectPing()
+		mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+			WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("13.10", lastScraped, lastScraped == nil, nil))
+		mock.ExpectQuery("SELECT instance_id, acquired_at, renewed_at FROM scraper_leader").
+			WillReturnRows(sqlmock.NewRows([]string{"instance_id", "acquired_at", "renewed_at"}).AddRow("host-1", leaderSince, renewedAt))
+		mock.ExpectQuery("SELECT name, last_started_at, last_finished_at, last_error, last_duration_ms FROM scheduled_jobs").
+			WillReturnRows(jobs)
+	}
+	get := func(r *gin.Engine) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	expect(nil, time.Now(), noJobs())
+	w := get(r)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), "no patch has finished scraping yet")
+
+	expect("13.10", time.Now(), noJobs())
+	w = get(r)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Ready":true`)
+	assert.Contains(t, w.Body.String(), `"Jobs":{"OK":true,"Detail":"no job running"}`)
+
+	// A stale scraper leader is reported, but only fails readiness when the
+	// scraper runs in this process.
+	stale := time.Now().Add(-time.Hour)
+	expect("13.10", stale, noJobs())
+	w = get(r)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Scraper":{"OK":false,"Detail":"scraper leader host-1 last renewed at`)
+
+	expect("13.10", stale, noJobs())
+	assert.Equal(t, 503, get(withScraper).Code)
+
+	// The lease keeps being renewed while a job hangs, so a job running far
+	// longer than its last run is reported as stuck on its own.
+	stuck := func() *sqlmock.Rows {
+		return noJobs().
+			AddRow("refresh", time.Now().Add(-4*time.Hour), time.Now().Add(-5*time.Hour), nil, 60000).
+			AddRow("full-scrape", time.Now().Add(-2*time.Hour), time.Now().Add(-3*time.Hour), nil, int64(time.Hour/time.Millisecond))
+	}
+	expect("13.10", time.Now(), stuck())
+	w = get(withScraper)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), `"Scraper":{"OK":true,"Detail":"host-1"}`)
+	assert.Contains(t, w.Body.String(), `"Jobs":{"OK":false,"Detail":"full-scrape running for 2h0m0s, its last run took 1h0m0s"}`)
+
+	expect("13.10", time.Now(), stuck())
+	assert.Equal(t, 200, get(r).Code)
+
+	// A job within its last run time plus SCRAPER_STALE_AFTER is fine, as is
+	// one that never finished a run, or one a previous leader started.
+	expect("13.10", time.Now(), noJobs().
+		AddRow("full-scrape", time.Now().Add(-62*time.Minute), nil, nil, int64(time.Hour/time.Millisecond)).
+		AddRow("refresh", time.Now().Add(-150*time.Minute), nil, nil, nil).
+		AddRow("cleanup", leaderSince.Add(-time.Hour), nil, nil, 1000))
+	w = get(withScraper)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"Jobs":{"OK":true,"Detail":"refresh running for 2h30m0s, its first run"}`)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestScrapeCheckpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectExec("INSERT INTO scrape_progress").WithArgs("13.10", "Ahri").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT champion FROM scrape_progress").WithArgs("13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"champion"}).AddRow("Ahri"))
+
+	assert.NoError(t, testDB.MarkChampionScraped(context.Background(), "13.10", "Ahri"))
+
+	done, err := testDB.GetScrapedChampions(context.Background(), "13.10")
+	assert.NoError(t, err)
+	assert.True(t, done["Ahri"])
+	assert.False(t, done["Zed"])
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestServeUntilDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- serveUntilDone(ctx, srv, time.Second) }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	assert.False(t, sleepContext(ctx, time.Hour))
+	assert.True(t, sleepContext(context.Background(), time.Millisecond))
+}
+
+func TestResponseCache(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	expectStatus := func(patch string) {
+		mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+			WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow(patch, patch, false, nil))
+	}
+	expectChampions := func() {
+		mock.ExpectQuery("SELECT name, avatar_url FROM champions").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Ahri", "http://example.com/ahri.png"))
+	}
+
+	expectStatus("13.10")
+	expectChampions()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=300", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Body.String(), "Ahri")
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+
+	// Same patch: served from memory, and revalidation gets a 304.
+	expectStatus("13.10")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "HIT", w.Header().Get("X-Cache"))
+	assert.Contains(t, w.Body.String(), "Ahri")
+
+	expectStatus("13.10")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/champions", nil)
+	req.Header.Set("If-None-Match", etag)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 304, w.Code)
+	assert.Empty(t, w.Body.String())
+
+	// If-None-Match is a list of tags, or *, compared weakly.
+	for header, code := range map[string]int{
+		`"other", ` + etag:   304,
+		`"other",` + etag:    304,
+		"W/" + etag:          304,
+		"*":                  304,
+		`"other", W/"stale"`: 200,
+	} {
+		expectStatus("13.10")
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/champions", nil)
+		req.Header.Set("If-None-Match", header)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, header)
+	}
+
+	// A new patch invalidates the cache.
+	expectStatus("13.11")
+	expectChampions()
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "MISS", w.Header().Get("X-Cache"))
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectQuery("SELECT name, avatar_url FROM champions").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Ahri", "http://example.com/ahri.png"))
+	scrapedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT champ.name, m.role, c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").WithArgs("13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"champion", "role", "opponent", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Ahri", "mid", "Yasuo", 53.1, 900, scrapedAt).
+			AddRow("Ahri", "mid", "Zed", 48.5, 1000, scrapedAt).
+			AddRow("Zed", "mid", "Ahri", 51.5, 1000, scrapedAt))
+
+	status := ScrapingStatus{CurrentPatch: "13.10", LastScrapedPatch: "13.10"}
+	store := &snapshotStore{}
+	assert.NoError(t, store.refresh(context.Background(), testDB, "13.10", servedVersion(status)))
+	assert.Nil(t, store.get("13.9"))
+
+	// A refresh of the same patch makes the snapshot stale.
+	refreshed := status
+	refreshed.RefreshedAt = &scrapedAt
+	assert.Nil(t, store.get(servedVersion(refreshed)))
+
+	// Every read below is answered without a query.
+	reader := &patchReader{db: testDB, snapshots: store}
+	top, err := reader.TopMatchups(context.Background(), "ahri", "MID", 1, status)
+	assert.NoError(t, err)
+	assert.Equal(t, []Matchup{{Champion: "Yasuo", WinRate: "53.10", SampleSize: "900", ScrapedAt: scrapedAt}}, top)
+
+	top, err = reader.TopMatchups(context.Background(), "ahri", "mid", 0, status)
+	assert.NoError(t, err)
+	assert.Len(t, top, 2)
+
+	all, err := reader.AllMatchups(context.Background(), "Zed", "mid", status)
+	assert.NoError(t, err)
+	assert.Len(t, all, 1)
+
+	results, err := reader.MatchupsBatch(context.Background(), []MatchupLookup{{Champion: "Ahri", Role: "mid", Opponent: "Zed"}}, 8, status)
+	assert.NoError(t, err)
+	assert.Equal(t, "48.50", results[0].Matchups[0].WinRate)
+
+	champions, err := reader.AllChampions(context.Background(), status)
+	assert.NoError(t, err)
+	assert.Equal(t, "Ahri", champions[0].Name)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRefreshQueries(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cutoff := time.Now().Add(-24 * time.Hour)
+	now := time.Now()
+
+	mock.ExpectQuery("SELECT champ.name FROM matchups .* HAVING MIN\\(m.scraped_at\\) < \\$2 ORDER BY SUM\\(m.sample_size\\)").
+		WithArgs("13.10", cutoff).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Briar").AddRow("Ahri"))
+	mock.ExpectQuery("UPDATE scraping_status SET refreshed_at = NOW\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"refreshed_at"}).AddRow(now))
+
+	stale, err := testDB.GetStaleChampions(context.Background(), "13.10", cutoff)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Briar", "Ahri"}, stale)
+
+	refreshedAt, err := testDB.MarkRefreshed(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, now, refreshedAt)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestPatchPromotion(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	ctx := context.Background()
+
+	mock.ExpectQuery("SELECT COUNT").WithArgs("13.11").
+		WillReturnRows(sqlmock.NewRows([]string{"count", "champions", "invalid", "total", "median"}).AddRow(400, 150, 0, 60000, 120.5))
+	mock.ExpectQuery("SELECT last_scraped_patch FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"last_scraped_patch"}).AddRow("13.10"))
+	mock.ExpectQuery("SELECT COUNT").WithArgs("13.10").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1000))
+
+	report, err := testDB.ValidateStaged(ctx, "13.11")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"only 400 matchups were staged, against 1000 for patch 13.10"}, report.Problems)
+
+	cfg := DefaultConfig()
+	cfg.PromotionMinTotalSamples = 50000
+	assert.Empty(t, sampleShortfall(report, cfg))
+	cfg.PromotionMinMedianSamples = 200
+	assert.Equal(t, []string{"median sample size is 120, below 200"}, sampleShortfall(report, cfg))
+
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT last_scraped_patch FROM scraping_status WHERE id = 1 FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"last_scraped_patch"}).AddRow("13.10"))
+	mock.ExpectExec("DELETE FROM matchups WHERE patch").WithArgs("13.11").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO matchups .* FROM matchups_staging").WithArgs("13.11").WillReturnResult(sqlmock.NewResult(0, 900))
+	mock.ExpectExec("DELETE FROM matchups_staging").WithArgs("13.11").WillReturnResult(sqlmock.NewResult(0, 900))
+	mock.ExpectExec("UPDATE patches SET promoted_at = COALESCE\\(promoted_at, NOW\\(\\)\\)").WithArgs("13.11").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("UPDATE scraping_status SET last_scraped_patch").WithArgs("13.11").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch"}).AddRow("13.11"))
+	mock.ExpectQuery("INSERT INTO patch_promotions").WithArgs("promote", "13.11", "13.10", "scraper host-1", "900 matchups").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "performed_at"}).AddRow(1, now))
+	mock.ExpectCommit()
+
+	promotion, err := testDB.PromotePatch(ctx, "13.11", "scraper host-1", "900 matchups")
+	assert.NoError(t, err)
+	assert.Equal(t, PatchPromotion{ID: 1, Action: "promote", Patch: "13.11", PreviousPatch: "13.10",
+		PerformedBy: "scraper host-1", Reason: "900 matchups", PerformedAt: now}, promotion)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating"}).AddRow("13.11", "13.11", false))
+	mock.ExpectQuery("SELECT previous_patch FROM patch_promotions").WithArgs("13.11").
+		WillReturnRows(sqlmock.NewRows([]string{"previous_patch"}).AddRow("13.10"))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs("13.10").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec("UPDATE scraping_status SET last_scraped_patch").WithArgs("13.10").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("INSERT INTO patch_promotions").WithArgs("rollback", "13.10", "13.11", "admin", "bad data").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "performed_at"}).AddRow(2, now))
+	mock.ExpectCommit()
+
+	rollback, err := testDB.RollbackPatch(ctx, "admin", "bad data")
+	assert.NoError(t, err)
+	assert.Equal(t, "13.10", rollback.Patch)
+
+	// Nothing was promoted before 13.10, so there is nowhere to go.
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating"}).AddRow("13.11", "13.10", false))
+	mock.ExpectQuery("SELECT previous_patch FROM patch_promotions").WithArgs("13.10").WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err = testDB.RollbackPatch(ctx, "admin", "bad data")
+	assert.ErrorIs(t, err, errNoRollbackTarget)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	r := NewRouter(testDB, DefaultConfig())
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/rollback", strings.NewReader(`{"Reason":"bad data"}`))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 403, w.Code)
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	r = NewRouter(testDB, cfg)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/rollback", strings.NewReader(`{"Reason":"bad data"}`))
+	req.Header.Set("Authorization", "Bearer wrong")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/rollback", strings.NewReader(`{}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestScheduler(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.Schedules["patch-check"] = scheduleOff
+	cfg.Schedules["full-scrape"] = scheduleOff
+	lastRefresh := time.Now().Add(-48 * time.Hour)
+
+	// refresh missed two runs while nothing was scheduling, and cleanup never
+	// ran: each runs once, the most overdue first.
+	mock.ExpectQuery("SELECT name, last_started_at, last_finished_at, last_error, last_duration_ms FROM scheduled_jobs").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "last_started_at", "last_finished_at", "last_error", "last_duration_ms"}).
+			AddRow("refresh", lastRefresh, lastRefresh.Add(time.Minute), nil, 60000))
+	mock.ExpectExec("INSERT INTO scheduled_jobs").WithArgs("refresh", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE scheduled_jobs SET last_finished_at = \\$2, last_error = \\$3, last_duration_ms").WithArgs("refresh", sqlmock.AnyArg(), "op.gg is down").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO scheduled_jobs").WithArgs("cleanup", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE scheduled_jobs SET last_finished_at").WithArgs("cleanup", sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran []string
+	s := newScheduler(testDB, cfg)
+	assert.NoError(t, s.Add("refresh", func(_ context.Context, lastStarted time.Time) error {
+		assert.True(t, lastStarted.Equal(lastRefresh))
+		ran = append(ran, "refresh")
+		return errors.New("op.gg is down")
+	}))
+	assert.NoError(t, s.Add("cleanup", func(_ context.Context, lastStarted time.Time) error {
+		assert.True(t, lastStarted.IsZero())
+		ran = append(ran, "cleanup")
+		cancel()
+		return nil
+	}))
+	assert.NoError(t, s.Add("patch-check", func(context.Context, time.Time) error {
+		t.Error("a job that is off must not run")
+		return nil
+	}))
+
+	assert.NoError(t, s.Run(ctx))
+	assert.Equal(t, []string{"refresh", "cleanup"}, ran)
+
+	started := time.Now().Add(-time.Minute)
+	mock.ExpectQuery("SELECT name, last_started_at, last_finished_at, last_error, last_duration_ms FROM scheduled_jobs").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "last_started_at", "last_finished_at", "last_error", "last_duration_ms"}).
+			AddRow("full-scrape", started, nil, nil, nil))
+
+	cfg.Schedules["full-scrape"] = "@every 2h"
+	r := NewRouter(testDB, cfg)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/schedule", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var resp struct{ Jobs []JobStatus }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Len(t, resp.Jobs, len(jobNames))
+	assert.Equal(t, "patch-check", resp.Jobs[0].Name)
+	assert.Nil(t, resp.Jobs[0].NextRunAt)
+	assert.Equal(t, "full-scrape", resp.Jobs[1].Name)
+	assert.True(t, resp.Jobs[1].Running)
+	assert.WithinDuration(t, started.Add(2*time.Hour), *resp.Jobs[1].NextRunAt, time.Second)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	ctx := context.Background()
+
+	// 13.1 is the served patch after a rollback, so it is kept however old.
+	planRows := func() *sqlmock.Rows {
+		// 13.20 was pruned before and is only kept for the promotion
+		// history, while 12.5 was pruned before and imported again.
+		return sqlmock.NewRows([]string{"version", "matchups", "staged", "progress", "audited", "in_use", "pruned"}).
+			AddRow("14.10", 1000, 0, 0, true, true, false).
+			AddRow("14.9", 900, 0, 0, true, false, false).
+			AddRow("13.24", 800, 0, 0, true, false, false).
+			AddRow("13.20", 0, 0, 0, true, false, true).
+			AddRow("13.1", 700, 0, 0, true, true, false).
+			AddRow("12.5", 300, 0, 0, true, false, true).
+			AddRow("9.24", 600, 50, 10, false, false, false)
+	}
+	mock.ExpectQuery("SELECT p.version, .* FROM patches p ORDER BY p.major DESC").WillReturnRows(planRows())
+
+	report, err := ApplyRetention(ctx, testDB, 2, true)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, []string{"14.10", "14.9", "13.1"}, report.Keep)
+	assert.Equal(t, []PatchPruning{
+		{Patch: "13.24", Season: 13, Matchups: 800},
+		{Patch: "12.5", Season: 12, Matchups: 300},
+		{Patch: "9.24", Season: 9, Matchups: 600, StagedMatchups: 50, ScrapeProgress: 10, DeletePatch: true},
+	}, report.Prune)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO season_matchups AS s .* ON CONFLICT \\(season, champion_id, opponent_id, role\\) DO UPDATE").
+		WithArgs("9.24", 9).WillReturnResult(sqlmock.NewResult(0, 600))
+	mock.ExpectExec("DELETE FROM matchups WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 600))
+	mock.ExpectExec("DELETE FROM matchups_staging WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 50))
+	mock.ExpectExec("DELETE FROM scrape_progress WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 10))
+	mock.ExpectExec("INSERT INTO pruned_patches .* DO UPDATE SET matchups = pruned_patches.matchups \\+ EXCLUDED.matchups").WithArgs("9.24", 9, int64(600)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM patches").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	pruned, err := testDB.PrunePatch(ctx, "9.24", 9)
+	assert.NoError(t, err)
+	assert.Equal(t, PatchPruning{Patch: "9.24", Season: 9, Matchups: 600, StagedMatchups: 50, ScrapeProgress: 10, DeletePatch: true}, pruned)
+
+	// Pruning 9.24 again, had its row been kept, deletes nothing and adds
+	// nothing to the recorded count, rather than resetting it.
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO season_matchups AS s").WithArgs("9.24", 9).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM matchups WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM matchups_staging WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM scrape_progress WHERE patch = \\$1").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO pruned_patches .* DO UPDATE SET matchups = pruned_patches.matchups \\+ EXCLUDED.matchups").WithArgs("9.24", 9, int64(0)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM patches").WithArgs("9.24").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	pruned, err = testDB.PrunePatch(ctx, "9.24", 9)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pruned.Matchups)
+
+	// Nor is a pruned patch kept for the history planned again.
+	mock.ExpectQuery("SELECT p.version, .* FROM patches p ORDER BY p.major DESC").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "matchups", "staged", "progress", "audited", "in_use", "pruned"}).
+			AddRow("14.10", 1000, 0, 0, true, true, false).
+			AddRow("9.24", 0, 0, 0, true, false, true))
+	report, err = ApplyRetention(ctx, testDB, 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"14.10"}, report.Keep)
+	assert.Empty(t, report.Prune)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSeasons(t *testing.T) {
+	starts := []PatchVersion{{14, 10}, {14, 19}}
+	assert.Equal(t, 1, splitOf(PatchVersion{14, 9}, starts))
+	assert.Equal(t, 2, splitOf(PatchVersion{14, 10}, starts))
+	assert.Equal(t, 3, splitOf(PatchVersion{14, 24}, starts))
+	assert.Equal(t, 1, splitOf(PatchVersion{15, 12}, starts))
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	mock.ExpectQuery("SELECT version, major, minor, split FROM patches").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "major", "minor", "split"}).
+			AddRow("14.9", 14, 9, 1).
+			AddRow("14.10", 14, 10, 1))
+	mock.ExpectExec("UPDATE patches SET split = \\$2").WithArgs("14.10", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, testDB.AssignSplits(context.Background(), starts))
+
+	cfg := DefaultConfig()
+	cfg.AdminToken = "secret"
+	r := NewRouter(testDB, cfg)
+
+	promoted := time.Date(2024, 5, 16, 10, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT version, .* FROM patches WHERE \\$1 = 0 OR season = \\$1").WithArgs(14).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "season", "split", "released_at", "first_seen_at", "promoted_at"}).
+			AddRow("14.10", 14, 2, promoted, promoted, promoted).
+			AddRow("14.9", 14, 1, nil, nil, nil))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/patches?season=14", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	var patches struct{ Patches []PatchInfo }
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &patches))
+	assert.Len(t, patches.Patches, 2)
+	assert.Equal(t, 2, patches.Patches[0].Split)
+	assert.True(t, promoted.Equal(*patches.Patches[0].PromotedAt))
+	assert.Nil(t, patches.Patches[1].PromotedAt)
+
+	// The season endpoint is cached per served patch, so it reads the status
+	// first.
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("WITH season_rows AS .* FROM season_matchups s").WithArgs(14, "Ahri", "mid").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Zed", 52.3, int64(120000), promoted).
+			AddRow("Yasuo", 48.1, int64(90000), nil))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/seasons/14/matchups/Ahri/mid", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"WinRate":"52.30"`)
+	assert.Contains(t, w.Body.String(), `"SampleSize":"120000"`)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/seasons/latest/matchups/Ahri/mid", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	mock.ExpectExec("UPDATE patches SET released_at").WithArgs("14.10", time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE patches SET released_at").WithArgs("99.1", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, tc := range []struct {
+		version string
+		code    int
+	}{{"14.10", 200}, {"99.1", 404}} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("PUT", "/admin/patches/"+tc.version, strings.NewReader(`{"ReleasedAt":"2024-05-15"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		r.ServeHTTP(w, req)
+		assert.Equal(t, tc.code, w.Code, tc.version)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAggregatedMatchups(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	for _, query := range []string{"?patches=0", "?patches=3&since=14.1", "?since=latest", "?breakdown=true", "?patches=2&breakdown=maybe"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/matchups/Ahri/mid"+query, nil)
+		r.ServeHTTP(w, req)
+		assert.Equal(t, 400, w.Code, query)
+	}
+
+	now := time.Now()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.11", "14.10", true, nil))
+	mock.ExpectQuery("SELECT p.version FROM patches p WHERE \\(p.major, p.minor\\) <= \\(\\$1, \\$2\\)").
+		WithArgs(14, 10, sql.NullInt64{}, sql.NullInt64{}, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow("14.10").AddRow("14.9"))
+	mock.ExpectQuery("SELECT c.name, SUM\\(m.win_rate \\* m.sample_size\\) / SUM\\(m.sample_size\\)").
+		WithArgs("Ahri", "mid", sqlmock.AnyArg(), 8).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "win_rate", "sample_size", "scraped_at"}).AddRow("Zed", 51.5, int64(3000), now))
+	mock.ExpectQuery("SELECT c.name, m.patch, m.win_rate, m.sample_size").
+		WithArgs("Ahri", "mid", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "patch", "win_rate", "sample_size"}).
+			AddRow("Zed", "14.10", 50.0, 1000).
+			AddRow("Yasuo", "14.10", 45.0, 500).
+			AddRow("Zed", "14.9", 52.25, 2000))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/matchups/Ahri/mid?patches=2&breakdown=true", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	var resp struct {
+		Patch    string
+		Patches  []string
+		Matchups []Matchup
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, "14.10", resp.Patch)
+	assert.Equal(t, []string{"14.10", "14.9"}, resp.Patches)
+	assert.Len(t, resp.Matchups, 1)
+	assert.Equal(t, "51.50", resp.Matchups[0].WinRate)
+	assert.Equal(t, "3000", resp.Matchups[0].SampleSize)
+	assert.Equal(t, []PatchMatchup{
+		{Patch: "14.10", WinRate: "50.00", SampleSize: "1000"},
+		{Patch: "14.9", WinRate: "52.25", SampleSize: "2000"},
+	}, resp.Matchups[0].Patches)
+
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("SELECT p.version FROM patches p").
+		WithArgs(14, 10, sql.NullInt64{Int64: 14, Valid: true}, sql.NullInt64{Int64: 1, Valid: true}, maxAggregatePatches).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/matchups/Ahri/mid/all?since=14.1", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	ctx := context.Background()
+	scrapedAt := time.Date(2024, 5, 20, 4, 0, 0, 0, time.UTC)
+	exportRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"patch", "champion", "opponent", "role", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("14.10", "Ahri", "Yasuo", "mid", 48.5, 900, scrapedAt).
+			AddRow("14.10", "Ahri", "Zed", "mid", 52.25, 1200, scrapedAt)
+	}
+	filter := ExportFilter{Patch: "14.10", Role: "mid"}
+
+	mock.ExpectQuery("SELECT m.patch, champ.name, c.name, m.role").WithArgs("14.10", "mid").WillReturnRows(exportRows())
+	var buf bytes.Buffer
+	n, err := ExportMatchups(ctx, testDB, filter, "csv", &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "Patch,Champion,Opponent,Role,WinRate,SampleSize,ScrapedAt\n"+
+		"14.10,Ahri,Yasuo,mid,48.5,900,2024-05-20T04:00:00Z\n"+
+		"14.10,Ahri,Zed,mid,52.25,1200,2024-05-20T04:00:00Z\n", buf.String())
+
+	mock.ExpectQuery("SELECT m.patch, champ.name, c.name, m.role").WithArgs("14.10", "mid").WillReturnRows(exportRows())
+	buf.Reset()
+	_, err = ExportMatchups(ctx, testDB, filter, "jsonl", &buf)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	var row ExportRow
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &row))
+	assert.Equal(t, ExportRow{Patch: "14.10", Champion: "Ahri", Opponent: "Zed", Role: "mid", WinRate: 52.25, SampleSize: 1200, ScrapedAt: scrapedAt}, row)
+
+	mock.ExpectQuery("SELECT m.patch, champ.name, c.name, m.role").WithArgs("14.10", "mid").WillReturnRows(exportRows())
+	buf.Reset()
+	_, err = ExportMatchups(ctx, testDB, filter, "parquet", &buf)
+	assert.NoError(t, err)
+	reader := parquet.NewGenericReader[ExportRow](bytes.NewReader(buf.Bytes()))
+	read := make([]ExportRow, 3)
+	count, _ := reader.Read(read)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, "Yasuo", read[0].Opponent)
+	assert.Equal(t, int64(1200), read[1].SampleSize)
+	assert.True(t, scrapedAt.Equal(read[1].ScrapedAt))
+
+	_, err = ExportMatchups(ctx, testDB, filter, "xlsx", &buf)
+	assert.Error(t, err)
+
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	r := NewRouter(testDB, cfg)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/export/matchups?format=xlsx", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.11", "14.10", true, nil))
+	mock.ExpectQuery("SELECT m.patch, champ.name, c.name, m.role").WithArgs("14.10", "mid").WillReturnRows(exportRows())
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/export/matchups?role=mid", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="matchups-14.10-mid.csv"`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, 3, strings.Count(w.Body.String(), "\n"))
+
+	mock.ExpectQuery("SELECT m.patch, champ.name, c.name, m.role").WithArgs("14.9", "").WillReturnError(sql.ErrConnDone)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/export/matchups?patch=14.9&format=jsonl", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 500, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestImport(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	ctx := context.Background()
+	scrapedAt := time.Date(2024, 5, 20, 4, 0, 0, 0, time.UTC)
+	statusColumns := []string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}
+	newChampion := func(name string) {
+		mock.ExpectQuery("SELECT name, COALESCE\\(avatar_url, ''\\) FROM champions WHERE LOWER\\(name\\) = LOWER\\(\\$1\\)").WithArgs(name).WillReturnError(sql.ErrNoRows)
+		mock.ExpectExec("INSERT INTO champions").WithArgs(name, "").WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+	existingChampion := func(name string, saved string) {
+		mock.ExpectQuery("SELECT name, COALESCE\\(avatar_url, ''\\) FROM champions WHERE LOWER\\(name\\) = LOWER\\(\\$1\\)").WithArgs(name).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow(saved, "http://example.com/"+saved+".png"))
+	}
+
+	// An empty database serves the newest imported patch.
+	mock.ExpectExec("INSERT INTO patches \\(version, major, minor, season\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").WithArgs("14.10", 14, 10, 14).WillReturnResult(sqlmock.NewResult(1, 1))
+	newChampion("Ahri")
+	newChampion("Yasuo")
+	newChampion("Zed")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Yasuo", "mid", 48.5, 900, "14.10", scrapedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Zed", "mid", 52.25, 1200, "14.10", scrapedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Zed", "Ahri", "mid", 47.75, 1200, "14.10", nil).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows(statusColumns))
+	mock.ExpectExec("INSERT INTO scraping_status").WithArgs("14.10", "14.10", false).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	csvFile := "Patch,Champion,Opponent,Role,WinRate,SampleSize,ScrapedAt\n" +
+		"14.10,Ahri,Yasuo,mid,48.5,900,2024-05-20T04:00:00Z\n" +
+		"14.10,Ahri,Zed,mid,52.25,1200,2024-05-20T04:00:00Z\n" +
+		"14.10,Zed,Ahri,mid,47.75,1200,\n"
+	report, err := ImportMatchups(ctx, testDB, "csv", strings.NewReader(csvFile))
+	assert.NoError(t, err)
+	assert.Equal(t, ImportReport{Matchups: 3, Champions: 3, Patches: []string{"14.10"}, Served: "14.10"}, report)
+
+	// Importing into the served patch tells the API to drop its caches.
+	// Names are matched to the saved champions ignoring case, so "zed"
+	// doesn't add a second Zed.
+	mock.ExpectExec("INSERT INTO patches \\(version, major, minor, season\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").WithArgs("14.10", 14, 10, 14).WillReturnResult(sqlmock.NewResult(1, 1))
+	existingChampion("Ahri", "Ahri")
+	existingChampion("zed", "Zed")
+	newChampion("Kai'Sa")
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Zed", "mid", 52.25, 1200, "14.10", scrapedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Kai'Sa", "mid", 50.0, 400, "14.10", scrapedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows(statusColumns).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("UPDATE scraping_status SET refreshed_at = NOW\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"refreshed_at"}).AddRow(time.Now()))
+
+	var buf bytes.Buffer
+	assert.NoError(t, json.NewEncoder(&buf).Encode(ExportRow{Patch: "14.10", Champion: "Ahri", Opponent: "zed", Role: "mid", WinRate: 52.25, SampleSize: 1200, ScrapedAt: scrapedAt}))
+	assert.NoError(t, json.NewEncoder(&buf).Encode(ExportRow{Patch: "14.10", Champion: "AHRI", Opponent: "Kai'Sa", Role: "mid", WinRate: 50, SampleSize: 400, ScrapedAt: scrapedAt}))
+	report, err = ImportMatchups(ctx, testDB, "jsonl", &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, ImportReport{Matchups: 2, Champions: 3, Patches: []string{"14.10"}}, report)
+
+	_, err = ImportMatchups(ctx, testDB, "csv", strings.NewReader("Patch,Champion\n14.10,Ahri\n"))
+	assert.ErrorIs(t, err, errInvalidImport)
+	_, err = ImportMatchups(ctx, testDB, "parquet", strings.NewReader(""))
+	assert.ErrorIs(t, err, errInvalidImport)
+
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	cfg.AdminToken = "secret"
+	r := NewRouter(testDB, cfg)
+
+	// A bad row is rejected before anything of it is saved.
+	mock.ExpectExec("INSERT INTO patches \\(version, major, minor, season\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").WithArgs("14.10", 14, 10, 14).WillReturnResult(sqlmock.NewResult(1, 1))
+	existingChampion("Ahri", "Ahri")
+	existingChampion("Zed", "Zed")
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/import?format=csv", strings.NewReader(
+		"Patch,Champion,Opponent,Role,WinRate,SampleSize\n14.10,Ahri,Zed,mid,52.25,1200\n14.10,Ahri,Yasuo,mid,148.5,900\n"))
+	req.Header.Set("Authorization", "Bearer secret")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+	assert.Contains(t, w.Body.String(), "row 2: win rate 148.5 is not a percentage")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/admin/import", strings.NewReader(csvFile))
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestReparse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	ctx := context.Background()
+	archive := &PageArchive{db: testDB, store: &diskPageStore{dir: t.TempDir()}}
+	fetchedAt := time.Date(2024, 5, 20, 4, 0, 0, 0, time.UTC)
+	page := `<html><body>
+		<div class="css-12a3bv1"><span class="css-72rvq0">Yasuo</span><span class="css-ekbdas">48.5%</span><span class="css-1nfew2i">900</span></div>
+		<div class="css-12a3bv1"><span class="css-72rvq0">Zed</span><span class="css-ekbdas">52.3%</span><span class="css-1nfew2i">1,200</span></div>
+	</body></html>`
+	sum := sha256.Sum256([]byte(page))
+	hash := hex.EncodeToString(sum[:])
+
+	// The same page archived twice is stored once but indexed twice.
+	for i := 0; i < 2; i++ {
+		mock.ExpectExec("INSERT INTO raw_pages").
+			WithArgs("14.10", pageMatchups, "Ahri", "mid", fetchedAt, hash, len(page)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+		err = archive.Save(ctx, RawPage{Patch: "14.10", Kind: pageMatchups, Champion: "Ahri", Role: "mid", FetchedAt: fetchedAt}, []byte(page))
+		assert.NoError(t, err)
+	}
+	stored, err := filepath.Glob(filepath.Join(archive.store.(*diskPageStore).dir, "pages", "*", "*.html.gz"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(archive.store.(*diskPageStore).dir, "pages", hash[:2], hash+".html.gz")}, stored)
+
+	pageColumns := []string{"id", "patch", "kind", "champion", "role", "fetched_at", "sha256", "size"}
+	filter := ReparseFilter{Patch: "14.10", Champion: "Ahri"}
+	expectPages := func(rows *sqlmock.Rows) {
+		mock.ExpectQuery("SELECT promoted_at IS NOT NULL").WithArgs("14.10").
+			WillReturnRows(sqlmock.NewRows([]string{"promoted"}).AddRow(true))
+		mock.ExpectQuery("SELECT DISTINCT ON \\(kind, champion, role\\)").WithArgs("14.10", "Ahri", "").WillReturnRows(rows)
+	}
+
+	expectPages(sqlmock.NewRows(pageColumns).AddRow(2, "14.10", pageMatchups, "Ahri", "mid", fetchedAt, hash, len(page)))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Yasuo", "mid", 48.5, 900, "14.10", fetchedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO matchups").WithArgs("Ahri", "Zed", "mid", 52.3, 1200, "14.10", fetchedAt).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("UPDATE scraping_status SET refreshed_at = NOW\\(\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"refreshed_at"}).AddRow(time.Now()))
+
+	report, err := Reparse(ctx, testDB, archive, filter, false)
+	assert.NoError(t, err)
+	assert.Equal(t, ReparseReport{Patch: "14.10", Pages: 1, Matchups: 2, Failed: []string{}}, report)
+
+	// A dry run parses without saving, and a page missing from the store
+	// is reported rather than failing the others.
+	expectPages(sqlmock.NewRows(pageColumns).
+		AddRow(2, "14.10", pageMatchups, "Ahri", "mid", fetchedAt, hash, len(page)).
+		AddRow(3, "14.10", pageMatchups, "Ahri", "top", fetchedAt, strings.Repeat("0", 64), 10))
+
+	report, err = Reparse(ctx, testDB, archive, filter, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Matchups)
+	assert.Len(t, report.Failed, 1)
+	assert.Contains(t, report.Failed[0], "Ahri top")
+
+	_, err = Reparse(ctx, testDB, nil, filter, false)
+	assert.Error(t, err)
+
+	_, err = OpenPageStore("s3://bucket/pages")
+	assert.Error(t, err)
+	store, err := OpenPageStore("s3://bucket/pages?endpoint=localhost:9000&insecure=true")
+	assert.NoError(t, err)
+	assert.Equal(t, "pages/"+pageKey(hash), store.(*s3PageStore).object(pageKey(hash)))
+	store, err = OpenPageStore("")
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestConcurrentScrapes(t *testing.T) {
+	if _, err := exec.LookPath("wget"); err != nil {
+		t.Skip("wget is not installed")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every role gets its own opponent, so a page read by the wrong
+		// scrape shows up in the results.
+		role := filepath.Base(r.URL.Path)
+		w.Write([]byte(`<div class="css-12a3bv1"><span class="css-72rvq0">` + role + `</span>` +
+			`<span class="css-ekbdas">50%</span><span class="css-1nfew2i">100</span></div>`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	roles := []string{"top", "mid"}
+	results := make(chan map[string][]Matchup, 8)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			matchups, err := ScrapeMatchups(context.Background(), server.URL, "Kai'Sa", roles, nil)
+			assert.NoError(t, err)
+			results <- matchups
+		}()
+	}
+	for i := 0; i < cap(results); i++ {
+		matchups := <-results
+		for _, role := range roles {
+			assert.Equal(t, []Matchup{{Champion: role, WinRate: "50", SampleSize: "100"}}, matchups[role])
+		}
+	}
+
+	// Nothing is left behind in the working directory.
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestAvatars(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+	var avatar bytes.Buffer
+	assert.NoError(t, png.Encode(&avatar, image.NewRGBA(image.Rect(0, 0, 120, 120))))
+	fetches := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(avatar.Bytes())
+	}))
+	defer upstream.Close()
+
+	cfg := DefaultConfig()
+	cfg.StatusCacheTTL = 0
+	cfg.Snapshot = false
+	cfg.AvatarDir = t.TempDir()
+	cfg.PublicURL = "https://pickhelper.lol/api"
+	r := NewRouter(testDB, cfg)
+
+	expectChampion := func(avatarURL string) {
+		mock.ExpectQuery("SELECT name, COALESCE\\(avatar_url, ''\\) FROM champions").WithArgs("ahri").
+			WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Ahri", avatarURL))
+	}
+
+	expectChampion(upstream.URL + "/ahri.png")
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/avatars/ahri?size=64", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=2592000", w.Header().Get("Cache-Control"))
+	resized, err := png.DecodeConfig(w.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, 64, resized.Width)
+	assert.Equal(t, 64, resized.Height)
+
+	// The original is only fetched once.
+	expectChampion(upstream.URL + "/ahri.png")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/avatars/ahri", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Equal(t, avatar.Bytes(), w.Body.Bytes())
+	assert.Equal(t, 1, fetches)
+
+	// When op.gg moves the avatar and can't be reached, the cached one is
+	// still served.
+	upstream.Close()
+	expectChampion(upstream.URL + "/v2/ahri.png")
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/avatars/ahri?size=32", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/avatars/ahri?size=50", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 400, w.Code)
+
+	mock.ExpectQuery("SELECT name, COALESCE\\(avatar_url, ''\\) FROM champions").WithArgs("nobody").WillReturnError(sql.ErrNoRows)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/avatars/nobody", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 404, w.Code)
+
+	// The champion list points at the proxy rather than op.gg.
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("SELECT name, avatar_url FROM champions").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Kai'Sa", "http://example.com/kaisa.png"))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"AvatarURL":"https://pickhelper.lol/api/avatars/Kai%27Sa"`)
+
+	// Without a public URL there is nothing absolute to point at, so op.gg's
+	// URL is kept.
+	cfg.PublicURL = ""
+	r = NewRouter(testDB, cfg)
+	mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").
+		WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow("14.10", "14.10", false, nil))
+	mock.ExpectQuery("SELECT name, avatar_url FROM champions").
+		WillReturnRows(sqlmock.NewRows([]string{"name", "avatar_url"}).AddRow("Kai'Sa", "http://example.com/kaisa.png"))
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"AvatarURL":"http://example.com/kaisa.png"`)
+	assert.NotContains(t, w.Body.String(), `/avatars/`)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMatchupsEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	r := gin.Default()
+	r.GET("/matchups/:champion/:role", func(c *gin.Context) {
+		champion := c.Param("champion")
+		role := c.Param("role")
+
+		status := ScrapingStatus{CurrentPatch: "13.10", LastScrapedPatch: "13.10", IsUpdating: false}
+		rows := sqlmock.NewRows([]string{"name", "win_rate", "sample_size", "scraped_at"}).
+			AddRow("Zed", 48.5, 1000, time.Now()).
+			AddRow("Yasuo", 51.2, 800, time.Now())
+
+		mock.ExpectQuery("SELECT current_patch, last_scraped_patch, is_updating, refreshed_at FROM scraping_status").WillReturnRows(sqlmock.NewRows([]string{"current_patch", "last_scraped_patch", "is_updating", "refreshed_at"}).AddRow(status.CurrentPatch, status.LastScrapedPatch, status.IsUpdating, nil))
+		mock.ExpectQuery("SELECT c.name, m.win_rate, m.sample_size, m.scraped_at FROM matchups").WithArgs(champion, role, status.LastScrapedPatch, 8).WillReturnRows(rows)
+
+		matchups, err := testDB.GetTopMatchups(context.Background(), champion, role, 8, status.LastScrapedPatch)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"patch": status.LastScrapedPatch, "matchups": matchups})
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/matchups/Ahri/mid", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "13.10")
+	assert.Contains(t, w.Body.String(), "Zed")
+	assert.Contains(t, w.Body.String(), "Yasuo")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestChampionsEndpoint(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	testDB := &DB{db}
+
+	r := gin.Default()
+	r.GET("/champions", func(c *gin.Context) {
+		rows := sqlmock.NewRows([]string{"name", "avatar_url"}).
+			AddRow("Ahri", "http://example.com/ahri.png").
+			AddRow("Zed", "http://example.com/zed.png")
+
+		mock.ExpectQuery("SELECT name, avatar_url FROM champions").WillReturnRows(rows)
+
+		champions, err := testDB.GetAllChampions(context.Background())
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		if len(champions) == 0 {
+			c.JSON(404, gin.H{"error": "No champions found"})
+			return
+		}
+
+		c.JSON(200, gin.H{"champions": champions})
+	})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/champions", nil)
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), "Ahri")
+	assert.Contains(t, w.Body.String(), "Zed")
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
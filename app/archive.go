@@ -0,0 +1,273 @@
+package app
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// The kinds of page the scraper archives.
+const (
+	pageChampions = "champions"
+	pageMatchups  = "matchups"
+)
+
+// PageStore keeps archived pages by key. Put must be safe to repeat.
+type PageStore interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// OpenPageStore opens the store named by archiveURL: a directory, either as
+// a path or a file:// URL, or an S3-compatible bucket as
+// s3://bucket/prefix?endpoint=host:port, with credentials taken from
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY. Add insecure=true to reach the
+// endpoint over plain HTTP, as with a local MinIO. An empty archiveURL means
+// no archive and returns nil.
+func OpenPageStore(archiveURL string) (PageStore, error) {
+	if archiveURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(archiveURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid archive URL: %v", err)
+	}
+
+	switch u.Scheme {
+	case "", "file":
+		dir := u.Path
+		if dir == "" {
+			return nil, fmt.Errorf("archive directory must be set")
+		}
+		return &diskPageStore{dir: dir}, nil
+	case "s3":
+		endpoint := u.Query().Get("endpoint")
+		if u.Host == "" || endpoint == "" {
+			return nil, fmt.Errorf("S3 archive URL must name a bucket and an endpoint, as in s3://bucket/prefix?endpoint=host:port")
+		}
+		insecure := false
+		if v := u.Query().Get("insecure"); v != "" {
+			if insecure, err = strconv.ParseBool(v); err != nil {
+				return nil, fmt.Errorf("invalid insecure in archive URL: %v", err)
+			}
+		}
+		client, err := minio.New(endpoint, &minio.Options{
+			Creds:  credentials.NewEnvAWS(),
+			Secure: !insecure,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating S3 client: %v", err)
+		}
+		return &s3PageStore{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+	}
+	return nil, fmt.Errorf("unknown archive URL scheme %q, expected a path, file:// or s3://", u.Scheme)
+}
+
+type diskPageStore struct {
+	dir string
+}
+
+func (s *diskPageStore) Put(ctx context.Context, key string, data []byte) error {
+	name := filepath.Join(s.dir, filepath.FromSlash(key))
+	if _, err := os.Stat(name); err == nil {
+		return nil
+	}
+	return writeFileAtomic(name, data)
+}
+
+// writeFileAtomic writes data to name under a temporary name and renames it,
+// so that the file is never seen half written, creating its directory if
+// needed.
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *diskPageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dir, filepath.FromSlash(key)))
+}
+
+type s3PageStore struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3PageStore) object(key string) string {
+	return path.Join(s.prefix, key)
+}
+
+func (s *s3PageStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.client.StatObject(ctx, s.bucket, s.object(key), minio.StatObjectOptions{})
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return err
+	}
+	_, err = s.client.PutObject(ctx, s.bucket, s.object(key), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "text/html", ContentEncoding: "gzip"})
+	return err
+}
+
+func (s *s3PageStore) Get(ctx context.Context, key string) ([]byte, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.object(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+	return io.ReadAll(obj)
+}
+
+// pageKey is where a page is stored: the SHA-256 of its contents, so a page
+// that didn't change between scrapes is only stored once.
+func pageKey(sum string) string {
+	return "pages/" + sum[:2] + "/" + sum + ".html.gz"
+}
+
+// PageArchive archives the pages the scraper downloads, compressed in a
+// PageStore and indexed in raw_pages by patch, champion, role and time, so
+// that matchups can be rebuilt from them later.
+type PageArchive struct {
+	db    *DB
+	store PageStore
+}
+
+// NewPageArchive opens the archive configured by cfg.ArchiveURL. It returns
+// nil when archiving is off.
+func NewPageArchive(db *DB, cfg Config) (*PageArchive, error) {
+	store, err := OpenPageStore(cfg.ArchiveURL)
+	if err != nil || store == nil {
+		return nil, err
+	}
+	return &PageArchive{db: db, store: store}, nil
+}
+
+// Save stores body compressed and indexes it as page, filling in its hash
+// and size.
+func (a *PageArchive) Save(ctx context.Context, page RawPage, body []byte) error {
+	sum := sha256.Sum256(body)
+	page.SHA256 = hex.EncodeToString(sum[:])
+	page.Size = len(body)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(body); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := a.store.Put(ctx, pageKey(page.SHA256), compressed.Bytes()); err != nil {
+		return fmt.Errorf("error storing page: %v", err)
+	}
+	return a.db.RecordRawPage(ctx, page)
+}
+
+// Load returns the contents of an archived page, checking them against its
+// hash.
+func (a *PageArchive) Load(ctx context.Context, page RawPage) ([]byte, error) {
+	compressed, err := a.store.Get(ctx, pageKey(page.SHA256))
+	if err != nil {
+		return nil, fmt.Errorf("error reading page: %v", err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing page: %v", err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing page: %v", err)
+	}
+	if sum := sha256.Sum256(body); hex.EncodeToString(sum[:]) != page.SHA256 {
+		return nil, errors.New("archived page is corrupt: its hash doesn't match")
+	}
+	return body, nil
+}
+
+// Recorder returns a PageRecorder archiving pages under patch. Failing to
+// archive a page is logged but doesn't fail the scrape. A nil archive
+// returns a nil recorder.
+func (a *PageArchive) Recorder(ctx context.Context, patch string) PageRecorder {
+	if a == nil {
+		return nil
+	}
+	return func(kind string, champion string, role string, body []byte) {
+		page := RawPage{Patch: patch, Kind: kind, Champion: champion, Role: role, FetchedAt: time.Now()}
+		if err := a.Save(ctx, page, body); err != nil {
+			loggerFrom(ctx).Error("Error archiving page", "kind", kind, "champion", champion, "role", role, "error", err)
+			pagesArchived.WithLabelValues("failure").Inc()
+			return
+		}
+		pagesArchived.WithLabelValues("success").Inc()
+	}
+}
+
+// RecordRawPage indexes an archived page.
+func (db *DB) RecordRawPage(ctx context.Context, page RawPage) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO raw_pages (patch, kind, champion, role, fetched_at, sha256, size)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, page.Patch, page.Kind, page.Champion, page.Role, page.FetchedAt, page.SHA256, page.Size)
+	return err
+}
+
+// GetRawPages returns the latest archived page of every kind, champion and
+// role of a patch. Filtering by champion or role leaves out the champions
+// page, which belongs to neither.
+func (db *DB) GetRawPages(ctx context.Context, filter ReparseFilter) ([]RawPage, error) {
+	defer observeQuery("GetRawPages", time.Now())
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT DISTINCT ON (kind, champion, role) id, patch, kind, champion, role, fetched_at, sha256, size
+		FROM raw_pages
+		WHERE patch = $1
+			AND ($2 = '' OR LOWER(champion) = LOWER($2))
+			AND ($3 = '' OR LOWER(role) = LOWER($3))
+		ORDER BY kind, champion, role, fetched_at DESC
+	`, filter.Patch, filter.Champion, filter.Role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pages []RawPage
+	for rows.Next() {
+		var p RawPage
+		if err := rows.Scan(&p.ID, &p.Patch, &p.Kind, &p.Champion, &p.Role, &p.FetchedAt, &p.SHA256, &p.Size); err != nil {
+			return nil, err
+		}
+		pages = append(pages, p)
+	}
+
+	return pages, rows.Err()
+}
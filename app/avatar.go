@@ -0,0 +1,199 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// avatarSizes are the widths, in pixels, that avatars can be resized to.
+var avatarSizes = []int{32, 64, 128}
+
+// avatarMaxAge is how long clients and CDNs may cache an avatar.
+const avatarMaxAge = 30 * 24 * time.Hour
+
+// maxAvatarBytes bounds the size of an avatar fetched from op.gg.
+const maxAvatarBytes = 5 << 20
+
+// errAvatarUnavailable means an avatar could neither be fetched nor found in
+// the cache.
+var errAvatarUnavailable = errors.New("avatar unavailable")
+
+// avatarURL is the URL the API gives out for a champion's avatar, under
+// publicURL.
+func avatarURL(publicURL string, champion string) string {
+	return publicURL + "/avatars/" + url.PathEscape(champion)
+}
+
+// withAvatarURLs returns a copy of champions pointing at our own avatars
+// rather than op.gg's CDN. Without a publicURL there is no absolute URL to
+// give out, so champions are returned as they are.
+func withAvatarURLs(champions []Champion, publicURL string) []Champion {
+	if publicURL == "" {
+		return champions
+	}
+	proxied := make([]Champion, len(champions))
+	for i, c := range champions {
+		proxied[i] = Champion{Name: c.Name, AvatarURL: avatarURL(publicURL, c.Name)}
+	}
+	return proxied
+}
+
+// GetChampion returns a champion by name, ignoring case, with its op.gg
+// avatar URL. It returns sql.ErrNoRows if the champion is unknown.
+func (db *DB) GetChampion(ctx context.Context, name string) (Champion, error) {
+	var c Champion
+	err := db.QueryRowContext(ctx, `
+		SELECT name, COALESCE(avatar_url, '')
+		FROM champions
+		WHERE LOWER(name) = LOWER($1)
+		LIMIT 1
+	`, name).Scan(&c.Name, &c.AvatarURL)
+	return c, err
+}
+
+// avatarCache keeps champion avatars on disk once fetched from op.gg, with
+// their resized variants.
+type avatarCache struct {
+	dir    string
+	client *http.Client
+}
+
+func newAvatarCache(dir string) *avatarCache {
+	return &avatarCache{dir: dir, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Open returns a champion's avatar, size pixels wide or as op.gg has it when
+// size is 0, fetching and resizing it on first use. Cached files are named
+// after the op.gg URL, so a new avatar is fetched when op.gg's changes. If
+// op.gg can't be reached, the latest avatar cached for the champion is used.
+func (a *avatarCache) Open(ctx context.Context, champ Champion, size int) (*os.File, error) {
+	key := transformChampionName(champ.Name)
+	sum := sha256.Sum256([]byte(champ.AvatarURL))
+	base := filepath.Join(a.dir, key+"-"+hex.EncodeToString(sum[:6]))
+	original := base + ".img"
+
+	if _, err := os.Stat(original); err != nil {
+		fetchErr := a.fetch(ctx, champ.AvatarURL, original)
+		if fetchErr != nil {
+			loggerFrom(ctx).Warn("Error fetching avatar", "champion", champ.Name, "url", champ.AvatarURL, "error", fetchErr)
+			if original = a.latest(key); original == "" {
+				return nil, fmt.Errorf("%w: %v", errAvatarUnavailable, fetchErr)
+			}
+			base = strings.TrimSuffix(original, ".img")
+		}
+	}
+	if size == 0 {
+		return os.Open(original)
+	}
+
+	resized := fmt.Sprintf("%s-%d.png", base, size)
+	if _, err := os.Stat(resized); err != nil {
+		if err := resizeAvatar(original, resized, size); err != nil {
+			return nil, fmt.Errorf("error resizing avatar: %v", err)
+		}
+	}
+	return os.Open(resized)
+}
+
+func (a *avatarCache) fetch(ctx context.Context, src string, dst string) error {
+	if src == "" {
+		return fmt.Errorf("no avatar URL known")
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", src, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxAvatarBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxAvatarBytes {
+		return fmt.Errorf("avatar is larger than %d bytes", maxAvatarBytes)
+	}
+	// op.gg answers some blocked requests with an HTML page.
+	if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("not an image: %v", err)
+	}
+	return writeFileAtomic(dst, data)
+}
+
+// latest returns the most recently fetched avatar cached for a champion, or
+// "" if there is none.
+func (a *avatarCache) latest(key string) string {
+	matches, _ := filepath.Glob(filepath.Join(a.dir, key+"-*.img"))
+	var latest string
+	var latestTime time.Time
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err == nil && info.ModTime().After(latestTime) {
+			latest, latestTime = m, info.ModTime()
+		}
+	}
+	return latest
+}
+
+// resizeAvatar scales the image in src to width pixels wide, keeping its
+// aspect ratio, and writes it to dst as a PNG.
+func resizeAvatar(src string, dst string, width int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return err
+	}
+
+	b := img.Bounds()
+	height := max(1, b.Dy()*width/max(1, b.Dx()))
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), img, b, draw.Over, nil)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaled); err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, buf.Bytes())
+}
+
+// parseAvatarSize reads ?size=, which is 0 when absent.
+func parseAvatarSize(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	size, err := strconv.Atoi(s)
+	if err != nil || !slices.Contains(avatarSizes, size) {
+		return 0, fmt.Errorf("size must be 32, 64 or 128")
+	}
+	return size, nil
+}
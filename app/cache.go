@@ -0,0 +1,221 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCachedResponses bounds the response cache. Champion/role/limit
+// combinations are finite, so hitting this means someone is enumerating junk
+// parameters and the cache is simply dropped.
+const maxCachedResponses = 10000
+
+// statusCache keeps the scraping status for a short time so that every API
+// request doesn't need its own round-trip just to learn which patch to serve.
+type statusCache struct {
+	db  *DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	status  ScrapingStatus
+	fetched time.Time
+}
+
+func newStatusCache(db *DB, ttl time.Duration) *statusCache {
+	return &statusCache{db: db, ttl: ttl}
+}
+
+func (sc *statusCache) Get(ctx context.Context) (ScrapingStatus, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if !sc.fetched.IsZero() && time.Since(sc.fetched) < sc.ttl {
+		return sc.status, nil
+	}
+
+	status, err := sc.db.GetScrapingStatus(ctx)
+	if err != nil {
+		return ScrapingStatus{}, err
+	}
+	sc.status = status
+	sc.fetched = time.Now()
+	return status, nil
+}
+
+// statusKey is the gin context key under which cacheResponses leaves the
+// status it read, so that the handler doesn't read it again.
+const statusKey = "scrapingStatus"
+
+// requestStatus returns the scraping status already read for this request,
+// or reads it through statuses.
+func requestStatus(c *gin.Context, statuses *statusCache) (ScrapingStatus, error) {
+	if status, ok := c.Get(statusKey); ok {
+		return status.(ScrapingStatus), nil
+	}
+	return statuses.Get(c.Request.Context())
+}
+
+// servedPatchOf returns the patch the API serves: the last promoted one, or the
+// one being scraped if none has been promoted yet.
+func servedPatchOf(status ScrapingStatus) string {
+	if status.LastScrapedPatch != "" {
+		return status.LastScrapedPatch
+	}
+	return status.CurrentPatch
+}
+
+// servedVersion identifies the data being served. It changes when another
+// patch is served or the served one is refreshed in place, so anything built
+// from served data is keyed by it.
+func servedVersion(status ScrapingStatus) string {
+	version := servedPatchOf(status)
+	if status.RefreshedAt != nil {
+		version += "@" + status.RefreshedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return version
+}
+
+type cachedResponse struct {
+	status      int
+	contentType string
+	body        []byte
+	etag        string
+}
+
+// responseCache holds rendered API responses for the data being served. All
+// entries are dropped as soon as a request sees a different servedVersion.
+type responseCache struct {
+	mu      sync.RWMutex
+	version string
+	entries map[string]cachedResponse
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+func (rc *responseCache) get(version, key string) (cachedResponse, bool) {
+	rc.mu.RLock()
+	defer rc.mu.RUnlock()
+	if rc.version != version {
+		return cachedResponse{}, false
+	}
+	resp, ok := rc.entries[key]
+	return resp, ok
+}
+
+func (rc *responseCache) set(version, key string, resp cachedResponse) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.version != version || len(rc.entries) >= maxCachedResponses {
+		rc.version = version
+		rc.entries = make(map[string]cachedResponse)
+	}
+	rc.entries[key] = resp
+}
+
+// bufferedWriter holds the response body back so that an ETag computed from
+// it can be set before anything reaches the client.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// cacheResponses serves GET responses from rc while the served data hasn't
+// changed, and adds ETag and Cache-Control headers so that clients and CDNs
+// can revalidate with If-None-Match instead of downloading the data again.
+// Only successful responses are cached.
+func cacheResponses(rc *responseCache, statuses *statusCache, maxAge time.Duration) gin.HandlerFunc {
+	cacheControl := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		status, err := statuses.Get(ctx)
+		if err != nil {
+			// Let the handler report the error.
+			c.Next()
+			return
+		}
+
+		c.Set(statusKey, status)
+
+		version := servedVersion(status)
+		key := strings.ToLower(c.Request.URL.Path) + "?" + c.Request.URL.Query().Encode()
+
+		if resp, ok := rc.get(version, key); ok {
+			if status.IsUpdating {
+				c.Header("X-Patch-Updating", "true")
+			}
+			c.Header("X-Cache", "HIT")
+			writeCached(c, resp, cacheControl)
+			c.Abort()
+			return
+		}
+
+		w := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		sum := sha256.Sum256(w.body.Bytes())
+		resp := cachedResponse{
+			status:      w.Status(),
+			contentType: w.Header().Get("Content-Type"),
+			body:        w.body.Bytes(),
+			etag:        `"` + hex.EncodeToString(sum[:16]) + `"`,
+		}
+		if resp.status == 200 {
+			rc.set(version, key, resp)
+		}
+		c.Header("X-Cache", "MISS")
+		writeCached(c, resp, cacheControl)
+	}
+}
+
+// etagMatches reports whether the If-None-Match header values match etag:
+// either "*" or any entity tag of their comma-separated lists, compared
+// weakly as RFC 9110 section 13.1.2 requires, so that W/"x" matches "x".
+func etagMatches(header []string, etag string) bool {
+	for _, value := range header {
+		for _, tag := range strings.Split(value, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || (tag != "" && strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/")) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func writeCached(c *gin.Context, resp cachedResponse, cacheControl string) {
+	if resp.status == 200 {
+		c.Header("ETag", resp.etag)
+		c.Header("Cache-Control", cacheControl)
+		if etagMatches(c.Request.Header.Values("If-None-Match"), resp.etag) {
+			c.Status(304)
+			c.Writer.WriteHeaderNow()
+			return
+		}
+	}
+	if resp.contentType != "" {
+		c.Header("Content-Type", resp.contentType)
+	}
+	c.Status(resp.status)
+	c.Writer.Write(resp.body)
+}
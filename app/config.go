@@ -0,0 +1,308 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	DatabaseURL       string        `yaml:"database_url"`
+	Port              int           `yaml:"port"`
+	AllowedOrigins    []string      `yaml:"allowed_origins"`
+	ScrapingDelay     time.Duration `yaml:"scraping_delay"`
+	LeaseRenewal      time.Duration `yaml:"lease_renewal"`
+	ScraperStaleAfter time.Duration `yaml:"scraper_stale_after"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	CacheMaxAge       time.Duration `yaml:"cache_max_age"`
+	StatusCacheTTL    time.Duration `yaml:"status_cache_ttl"`
+	Snapshot          bool          `yaml:"snapshot"`
+	Roles             []string      `yaml:"roles"`
+	OpGGBaseURL       string        `yaml:"opgg_base_url"`
+	LogLevel          string        `yaml:"log_level"`
+	AdminToken        string        `yaml:"admin_token"`
+
+	PromotionMinTotalSamples  int `yaml:"promotion_min_total_samples"`
+	PromotionMinMedianSamples int `yaml:"promotion_min_median_samples"`
+	RetainPatches             int `yaml:"retain_patches"`
+
+	// SplitStarts lists the first patch of every split after the first of
+	// its season.
+	SplitStarts []string `yaml:"split_starts"`
+
+	// Schedules maps each scraper job to a cron expression, or "off".
+	Schedules map[string]string `yaml:"schedules"`
+
+	// ArchiveURL is where downloaded pages are archived: a directory or an
+	// s3:// URL. Empty turns archiving off.
+	ArchiveURL string `yaml:"archive_url"`
+
+	// AvatarDir is where champion avatars are cached once fetched.
+	AvatarDir string `yaml:"avatar_dir"`
+	// PublicURL is the API's address as seen by clients, which the avatar
+	// URLs it gives out start with. Empty gives out op.gg's avatar URLs.
+	PublicURL string `yaml:"public_url"`
+
+	// RunsScraper is set when the scraper runs in the same process as the
+	// API. It isn't read from the configuration.
+	RunsScraper bool `yaml:"-"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Port:              8080,
+		AllowedOrigins:    []string{"http://localhost:3000"},
+		ScrapingDelay:     30 * time.Second,
+		LeaseRenewal:      30 * time.Second,
+		ScraperStaleAfter: 5 * time.Minute,
+		ShutdownTimeout:   30 * time.Second,
+		CacheMaxAge:       5 * time.Minute,
+		StatusCacheTTL:    10 * time.Second,
+		Snapshot:          true,
+		Roles:             []string{"top", "jungle", "mid", "adc", "support"},
+		OpGGBaseURL:       "https://www.op.gg",
+		LogLevel:          "info",
+		AvatarDir:         filepath.Join(os.TempDir(), "pickhelper-avatars"),
+
+		PromotionMinTotalSamples:  500000,
+		PromotionMinMedianSamples: 100,
+		RetainPatches:             6,
+
+		Schedules: map[string]string{
+			"patch-check": "*/30 * * * *",
+			"full-scrape": "0 */2 * * *",
+			"refresh":     "0 5 * * *",
+			"cleanup":     "30 3 * * *",
+			"retention":   "0 4 * * 0",
+		},
+	}
+}
+
+// LoadConfig builds the configuration from the defaults, then the YAML file
+// named by CONFIG_FILE if it is set, then individual environment variables.
+// Later sources override earlier ones.
+func LoadConfig() (Config, error) {
+	cfg := DefaultConfig()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, fmt.Errorf("error reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("error parsing config file: %v", err)
+		}
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+
+	cfg.OpGGBaseURL = strings.TrimRight(cfg.OpGGBaseURL, "/")
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+func applyEnv(cfg *Config) error {
+	if v := os.Getenv("DATABASE_URL"); v != "" {
+		cfg.DatabaseURL = v
+	}
+	if v := os.Getenv("PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid PORT: %v", err)
+		}
+		cfg.Port = port
+	}
+	if v := os.Getenv("CORS_ALLOWED_ORIGINS"); v != "" {
+		cfg.AllowedOrigins = splitList(v)
+	}
+	if v := os.Getenv("SPLIT_STARTS"); v != "" {
+		cfg.SplitStarts = splitList(v)
+	}
+	if v := os.Getenv("SCRAPE_ROLES"); v != "" {
+		cfg.Roles = splitList(v)
+	}
+	if v := os.Getenv("OPGG_BASE_URL"); v != "" {
+		cfg.OpGGBaseURL = v
+	}
+	if v := os.Getenv("SNAPSHOT"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid SNAPSHOT: %v", err)
+		}
+		cfg.Snapshot = enabled
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if v := os.Getenv("ADMIN_TOKEN"); v != "" {
+		cfg.AdminToken = v
+	}
+	if v := os.Getenv("ARCHIVE_URL"); v != "" {
+		cfg.ArchiveURL = v
+	}
+	if v := os.Getenv("AVATAR_DIR"); v != "" {
+		cfg.AvatarDir = v
+	}
+	if v := os.Getenv("PUBLIC_URL"); v != "" {
+		cfg.PublicURL = v
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"SCRAPING_DELAY", &cfg.ScrapingDelay},
+		{"LEASE_RENEWAL", &cfg.LeaseRenewal},
+		{"SCRAPER_STALE_AFTER", &cfg.ScraperStaleAfter},
+		{"SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout},
+		{"CACHE_MAX_AGE", &cfg.CacheMaxAge},
+		{"STATUS_CACHE_TTL", &cfg.StatusCacheTTL},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", d.name, err)
+		}
+		*d.dst = parsed
+	}
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"PROMOTION_MIN_TOTAL_SAMPLES", &cfg.PromotionMinTotalSamples},
+		{"PROMOTION_MIN_MEDIAN_SAMPLES", &cfg.PromotionMinMedianSamples},
+		{"RETAIN_PATCHES", &cfg.RetainPatches},
+	}
+	for _, i := range ints {
+		v := os.Getenv(i.name)
+		if v == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %v", i.name, err)
+		}
+		*i.dst = parsed
+	}
+
+	for _, job := range jobNames {
+		if v := os.Getenv("SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(job, "-", "_"))); v != "" {
+			if cfg.Schedules == nil {
+				cfg.Schedules = make(map[string]string)
+			}
+			cfg.Schedules[job] = v
+		}
+	}
+
+	return nil
+}
+
+func (cfg Config) Validate() error {
+	if cfg.DatabaseURL == "" {
+		return fmt.Errorf("database_url must be set (DATABASE_URL)")
+	}
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		return fmt.Errorf("port must be between 1 and 65535, got %d", cfg.Port)
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one allowed origin must be set")
+	}
+	if cfg.PromotionMinTotalSamples < 0 || cfg.PromotionMinMedianSamples < 0 {
+		return fmt.Errorf("promotion sample thresholds cannot be negative")
+	}
+	// The served patch and the one a rollback would go back to.
+	if cfg.RetainPatches < 2 {
+		return fmt.Errorf("retain_patches must be at least 2, got %d", cfg.RetainPatches)
+	}
+	if cfg.ScrapingDelay < 0 {
+		return fmt.Errorf("scraping_delay cannot be negative")
+	}
+	if cfg.LeaseRenewal <= 0 {
+		return fmt.Errorf("lease_renewal must be positive")
+	}
+	if cfg.ScraperStaleAfter <= cfg.LeaseRenewal {
+		return fmt.Errorf("scraper_stale_after must be longer than lease_renewal")
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		return fmt.Errorf("shutdown_timeout must be positive")
+	}
+	if cfg.CacheMaxAge < 0 {
+		return fmt.Errorf("cache_max_age cannot be negative")
+	}
+	if cfg.StatusCacheTTL < 0 {
+		return fmt.Errorf("status_cache_ttl cannot be negative")
+	}
+	for _, s := range cfg.SplitStarts {
+		if _, err := ParsePatchVersion(s); err != nil {
+			return fmt.Errorf("split_starts: %v", err)
+		}
+	}
+	for _, job := range jobNames {
+		spec, ok := cfg.Schedules[job]
+		if !ok {
+			return fmt.Errorf("schedules.%s must be set", job)
+		}
+		if _, err := parseSchedule(spec); err != nil {
+			return fmt.Errorf("schedules.%s must be a cron expression or %q, got %q: %v", job, scheduleOff, spec, err)
+		}
+	}
+	for job := range cfg.Schedules {
+		if !slices.Contains(jobNames, job) {
+			return fmt.Errorf("unknown job %q in schedules, expected one of %s", job, strings.Join(jobNames, ", "))
+		}
+	}
+	if len(cfg.Roles) == 0 {
+		return fmt.Errorf("at least one role must be set")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("log_level must be one of debug, info, warn or error, got %q", cfg.LogLevel)
+	}
+	if _, err := OpenPageStore(cfg.ArchiveURL); err != nil {
+		return fmt.Errorf("archive_url: %v", err)
+	}
+	if cfg.AvatarDir == "" {
+		return fmt.Errorf("avatar_dir must be set")
+	}
+	if cfg.PublicURL != "" {
+		u, err := url.Parse(cfg.PublicURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("public_url must be an absolute URL, got %q", cfg.PublicURL)
+		}
+	}
+	u, err := url.Parse(cfg.OpGGBaseURL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("opgg_base_url must be an absolute URL, got %q", cfg.OpGGBaseURL)
+	}
+	return nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,519 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type DB struct {
+	*sql.DB
+}
+
+func NewDB(dataSourceName string) (*DB, error) {
+	db, err := sql.Open("postgres", dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.Ping(); err != nil {
+		return nil, err
+	}
+	return &DB{db}, nil
+}
+
+// Open connects to the configured database and applies any pending
+// migrations.
+func Open(cfg Config) (*DB, error) {
+	slog.Info("Connecting to database")
+	db, err := NewDB(cfg.DatabaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the database: %v", err)
+	}
+
+	if err := db.Migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	slog.Info("Database schema is up to date")
+
+	return db, nil
+}
+
+func (db *DB) SavePatch(ctx context.Context, patch PatchInfo) error {
+	v, err := ParsePatchVersion(patch.Version)
+	if err != nil {
+		return err
+	}
+	// The first sighting sets the release date and first-seen time; later
+	// ones leave them alone.
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO patches (version, major, minor, season, first_seen_at, released_at)
+		VALUES ($1, $2, $3, $4, NOW(), CURRENT_DATE)
+		ON CONFLICT (version) DO UPDATE SET major = EXCLUDED.major, minor = EXCLUDED.minor, season = EXCLUDED.season,
+			first_seen_at = COALESCE(patches.first_seen_at, EXCLUDED.first_seen_at)
+	`, patch.Version, v.Major, v.Minor, v.Season())
+	return err
+}
+
+func (db *DB) UpdateScrapingStatus(ctx context.Context, status ScrapingStatus) error {
+	if status.CurrentPatch == "" {
+		return fmt.Errorf("current_patch cannot be empty")
+	}
+
+	// If last_scraped_patch is empty, set it to NULL in the database
+	var lastScrapedPatch interface{}
+	if status.LastScrapedPatch == "" {
+		lastScrapedPatch = nil
+	} else {
+		lastScrapedPatch = status.LastScrapedPatch
+	}
+
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO scraping_status (id, current_patch, last_scraped_patch, is_updating)
+        VALUES (1, $1, $2, $3)
+        ON CONFLICT (id) DO UPDATE 
+        SET current_patch = $1, last_scraped_patch = $2, is_updating = $3
+    `, status.CurrentPatch, lastScrapedPatch, status.IsUpdating)
+	if err == nil {
+		recordScrapingStatus(status)
+	}
+	return err
+}
+
+func (db *DB) GetCurrentPatch(ctx context.Context) (PatchInfo, error) {
+	var patch PatchInfo
+	err := db.QueryRowContext(ctx, `
+		SELECT version
+		FROM patches
+		ORDER BY major DESC NULLS LAST, minor DESC NULLS LAST
+		LIMIT 1
+	`).Scan(&patch.Version)
+	return patch, err
+}
+
+// SaveChampion adds a champion or updates its avatar. An empty AvatarURL
+// leaves a known avatar alone.
+func (db *DB) SaveChampion(ctx context.Context, champ Champion) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO champions (name, avatar_url)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET avatar_url = COALESCE(NULLIF($2, ''), champions.avatar_url)
+	`, champ.Name, champ.AvatarURL)
+	return err
+}
+
+// SaveMatchups upserts the matchups of champName in role on patch. Both
+// champions of a matchup must already be saved. A zero ScrapedAt means now.
+func (db *DB) SaveMatchups(ctx context.Context, champName string, role string, matchups []Matchup, patch string) error {
+	return db.saveMatchups(ctx, "matchups", champName, role, matchups, patch)
+}
+
+// StageMatchups is SaveMatchups for a patch that isn't served yet: the rows go
+// to matchups_staging until PromotePatch copies them over.
+func (db *DB) StageMatchups(ctx context.Context, champName string, role string, matchups []Matchup, patch string) error {
+	return db.saveMatchups(ctx, "matchups_staging", champName, role, matchups, patch)
+}
+
+func (db *DB) saveMatchups(ctx context.Context, table string, champName string, role string, matchups []Matchup, patch string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range matchups {
+		winRate, err := strconv.ParseFloat(m.WinRate, 64)
+		if err != nil {
+			loggerFrom(ctx).Warn("Error parsing win rate", "champion", champName, "opponent", m.Champion, "error", err)
+			continue
+		}
+
+		sampleSize, err := strconv.Atoi(strings.ReplaceAll(m.SampleSize, ",", ""))
+		if err != nil {
+			loggerFrom(ctx).Warn("Error parsing sample size", "champion", champName, "opponent", m.Champion, "error", err)
+			continue
+		}
+
+		var scrapedAt interface{}
+		if !m.ScrapedAt.IsZero() {
+			scrapedAt = m.ScrapedAt
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			WITH champ AS (
+				SELECT id FROM champions WHERE name = $1
+			), opp AS (
+				SELECT id FROM champions WHERE name = $2
+			)
+			INSERT INTO `+table+` (champion_id, opponent_id, role, win_rate, sample_size, patch, scraped_at)
+			SELECT champ.id, opp.id, $3, $4, $5, $6, COALESCE($7::TIMESTAMPTZ, NOW())
+			FROM champ, opp
+			ON CONFLICT (champion_id, opponent_id, role, patch) 
+			DO UPDATE SET win_rate = $4, sample_size = $5, scraped_at = EXCLUDED.scraped_at
+		`, champName, m.Champion, role, winRate, sampleSize, patch, scrapedAt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// MarkChampionScraped checkpoints that every role of champName has been
+// scraped for patch, so an interrupted update can skip it when resumed.
+func (db *DB) MarkChampionScraped(ctx context.Context, patch string, champName string) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO scrape_progress (patch, champion)
+		VALUES ($1, $2)
+		ON CONFLICT (patch, champion) DO UPDATE SET scraped_at = NOW()
+	`, patch, champName)
+	return err
+}
+
+// ClearScrapeProgress forgets which champions of patch were scraped, so that
+// the next run scrapes all of them again.
+func (db *DB) ClearScrapeProgress(ctx context.Context, patch string) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM scrape_progress WHERE patch = $1", patch)
+	return err
+}
+
+// GetScrapedChampions returns the champions already checkpointed for patch.
+func (db *DB) GetScrapedChampions(ctx context.Context, patch string) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT champion FROM scrape_progress WHERE patch = $1", patch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		done[name] = true
+	}
+
+	return done, rows.Err()
+}
+
+func (db *DB) GetScrapingStatus(ctx context.Context) (ScrapingStatus, error) {
+	var status ScrapingStatus
+	var lastScrapedPatch sql.NullString
+	var refreshedAt sql.NullTime
+
+	err := db.QueryRowContext(ctx, `
+        SELECT current_patch, last_scraped_patch, is_updating, refreshed_at
+        FROM scraping_status
+        WHERE id = 1
+    `).Scan(&status.CurrentPatch, &lastScrapedPatch, &status.IsUpdating, &refreshedAt)
+
+	if err == sql.ErrNoRows {
+		return ScrapingStatus{}, nil
+	}
+
+	if err != nil {
+		return ScrapingStatus{}, err
+	}
+
+	if lastScrapedPatch.Valid {
+		status.LastScrapedPatch = lastScrapedPatch.String
+	} else {
+		status.LastScrapedPatch = ""
+	}
+	if refreshedAt.Valid {
+		status.RefreshedAt = &refreshedAt.Time
+	}
+
+	recordScrapingStatus(status)
+	return status, nil
+}
+
+// GetScraperLeader returns the instance that most recently held the scraper
+// lease. An empty InstanceID means no scraper has ever become leader.
+func (db *DB) GetScraperLeader(ctx context.Context) (LeaderInfo, error) {
+	var leader LeaderInfo
+	err := db.QueryRowContext(ctx, `
+		SELECT instance_id, acquired_at, renewed_at
+		FROM scraper_leader
+		WHERE id = 1
+	`).Scan(&leader.InstanceID, &leader.AcquiredAt, &leader.RenewedAt)
+
+	if err == sql.ErrNoRows {
+		return LeaderInfo{}, nil
+	}
+
+	return leader, err
+}
+
+func (db *DB) InitializeScrapingStatus(ctx context.Context) (ScrapingStatus, error) {
+	status := ScrapingStatus{
+		CurrentPatch:     "",
+		LastScrapedPatch: "",
+		IsUpdating:       false,
+	}
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO scraping_status (id, current_patch, last_scraped_patch, is_updating)
+		VALUES (1, $1, $2, $3)
+		ON CONFLICT (id) DO UPDATE 
+		SET current_patch = $1, last_scraped_patch = $2, is_updating = $3
+	`, status.CurrentPatch, status.LastScrapedPatch, status.IsUpdating)
+
+	return status, err
+}
+
+// GetTopMatchups returns the best matchups of a champion in a role on patch,
+// highest win rate first. A limit of 0 returns every matchup.
+func (db *DB) GetTopMatchups(ctx context.Context, champName string, role string, limit int, patch string) ([]Matchup, error) {
+	defer observeQuery("GetTopMatchups", time.Now())
+
+	var sqlLimit interface{}
+	if limit > 0 {
+		sqlLimit = limit
+	}
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.name, m.win_rate, m.sample_size, m.scraped_at
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE LOWER(champ.name) = LOWER($1) AND LOWER(m.role) = LOWER($2) AND m.patch = $3
+		ORDER BY m.win_rate DESC
+		LIMIT $4
+	`, champName, role, patch, sqlLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matchups []Matchup
+	for rows.Next() {
+		var m Matchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.Itoa(sampleSize)
+		matchups = append(matchups, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return matchups, nil
+}
+
+func (db *DB) GetAllMatchups(ctx context.Context, champName string, role string, patch string) ([]Matchup, error) {
+	defer observeQuery("GetAllMatchups", time.Now())
+	logger := loggerFrom(ctx)
+	logger.Debug("GetAllMatchups called", "champion", champName, "role", role, "patch", patch)
+
+	query := `
+		SELECT c.name, m.win_rate, m.sample_size, m.scraped_at
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE LOWER(champ.name) = LOWER($1) AND LOWER(m.role) = LOWER($2) AND m.patch = $3
+		ORDER BY m.win_rate DESC
+	`
+
+	rows, err := db.QueryContext(ctx, query, champName, role, patch)
+	if err != nil {
+		logger.Error("Error executing query", "error", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matchups []Matchup
+	for rows.Next() {
+		var m Matchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			logger.Error("Error scanning row", "error", err)
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.Itoa(sampleSize)
+		matchups = append(matchups, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		logger.Error("Error after iterating rows", "error", err)
+		return nil, err
+	}
+
+	logger.Debug("Retrieved matchups", "count", len(matchups))
+
+	return matchups, nil
+}
+
+func (db *DB) GetAllChampions(ctx context.Context) ([]Champion, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, avatar_url FROM champions ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var champions []Champion
+	for rows.Next() {
+		var c Champion
+		if err := rows.Scan(&c.Name, &c.AvatarURL); err != nil {
+			return nil, err
+		}
+		champions = append(champions, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return champions, nil
+}
+
+func (db *DB) GetMatchupsBatch(ctx context.Context, lookups []MatchupLookup, limit int, patch string) ([]MatchupLookupResult, error) {
+	// Each champion and role pair is fetched once, however many lookups ask
+	// for it.
+	champNames := make([]string, 0, len(lookups))
+	roles := make([]string, 0, len(lookups))
+	seen := make(map[string]bool, len(lookups))
+	for _, l := range lookups {
+		if key := matchupKey(l.Champion, l.Role); !seen[key] {
+			seen[key] = true
+			champNames = append(champNames, strings.ToLower(l.Champion))
+			roles = append(roles, strings.ToLower(l.Role))
+		}
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT champ.name, m.role, c.name, m.win_rate, m.sample_size, m.scraped_at
+		FROM unnest($1::text[], $2::text[]) AS l(champion, role)
+		JOIN champions champ ON LOWER(champ.name) = l.champion
+		JOIN matchups m ON m.champion_id = champ.id AND LOWER(m.role) = l.role
+		JOIN champions c ON m.opponent_id = c.id
+		WHERE m.patch = $3
+		ORDER BY m.win_rate DESC
+	`, pq.Array(champNames), pq.Array(roles), patch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Rows are grouped by lowercased champion and role so every lookup for the
+	// same pair can be answered from the one result set.
+	grouped := make(map[string][]Matchup)
+	for rows.Next() {
+		var champName, role string
+		var m Matchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&champName, &role, &m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.Itoa(sampleSize)
+		key := matchupKey(champName, role)
+		grouped[key] = append(grouped[key], m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return resolveLookups(grouped, lookups, limit), nil
+}
+
+// resolveLookups answers each lookup from matchups grouped by lowercased
+// "champion/role", ordered by win rate.
+func resolveLookups(grouped map[string][]Matchup, lookups []MatchupLookup, limit int) []MatchupLookupResult {
+	results := make([]MatchupLookupResult, 0, len(lookups))
+	for _, l := range lookups {
+		result := MatchupLookupResult{Champion: l.Champion, Role: l.Role, Opponent: l.Opponent, Matchups: []Matchup{}}
+		for _, m := range grouped[matchupKey(l.Champion, l.Role)] {
+			if l.Opponent != "" {
+				if strings.EqualFold(m.Champion, l.Opponent) {
+					result.Matchups = append(result.Matchups, m)
+					break
+				}
+				continue
+			}
+			if limit > 0 && len(result.Matchups) >= limit {
+				break
+			}
+			result.Matchups = append(result.Matchups, m)
+		}
+		results = append(results, result)
+	}
+
+	return results
+}
+
+func matchupKey(champName string, role string) string {
+	return strings.ToLower(champName) + "/" + strings.ToLower(role)
+}
+
+// GetHeadToHead returns both perspectives of a single matchup: the champion's
+// win rate as scraped from its own counters page and the opponent's win rate
+// from the opponent's page. The two should add up to roughly 100%, so the gap
+// between them is reported as the discrepancy.
+func (db *DB) GetHeadToHead(ctx context.Context, champName string, opponent string, role string, patch string, threshold float64) (HeadToHead, error) {
+	h2h := HeadToHead{Champion: champName, Opponent: opponent, Role: role}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT champ.name, c.name, m.win_rate, m.sample_size, m.scraped_at
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE LOWER(m.role) = LOWER($3) AND m.patch = $4
+		AND ((LOWER(champ.name) = LOWER($1) AND LOWER(c.name) = LOWER($2))
+			OR (LOWER(champ.name) = LOWER($2) AND LOWER(c.name) = LOWER($1)))
+	`, champName, opponent, role, patch)
+	if err != nil {
+		return h2h, err
+	}
+	defer rows.Close()
+
+	var champWinRate, oppWinRate float64
+	for rows.Next() {
+		var name string
+		var m Matchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&name, &m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			return h2h, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.Itoa(sampleSize)
+		if strings.EqualFold(name, champName) {
+			h2h.Champion = name
+			h2h.ChampionView = &m
+			champWinRate = winRate
+		} else {
+			h2h.Opponent = name
+			h2h.OpponentView = &m
+			oppWinRate = winRate
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return h2h, err
+	}
+
+	if h2h.ChampionView != nil && h2h.OpponentView != nil {
+		discrepancy := math.Abs(champWinRate + oppWinRate - 100)
+		h2h.Discrepancy = fmt.Sprintf("%.2f", discrepancy)
+		h2h.Disagree = discrepancy > threshold
+	}
+
+	return h2h, nil
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// exportFormats maps each export format to its content type.
+var exportFormats = map[string]string{
+	"csv":     "text/csv",
+	"jsonl":   "application/x-ndjson",
+	"parquet": "application/vnd.apache.parquet",
+}
+
+// exportRowGroupSize is how many rows a Parquet export buffers before writing
+// them out as a row group, which bounds its memory use.
+const exportRowGroupSize = 50000
+
+// exportColumns are the CSV header, in the order of ExportRow's fields.
+var exportColumns = []string{"Patch", "Champion", "Opponent", "Role", "WinRate", "SampleSize", "ScrapedAt"}
+
+// ExportRow is one matchup as exported and imported: Champion's win rate
+// against Opponent in Role on Patch.
+type ExportRow struct {
+	Patch      string
+	Champion   string
+	Opponent   string
+	Role       string
+	WinRate    float64
+	SampleSize int64
+	ScrapedAt  time.Time
+}
+
+type ExportFilter struct {
+	Patch string
+	// Role is optional; empty exports every role.
+	Role string
+}
+
+// StreamMatchups calls fn for every matchup matching filter, ordered by
+// champion, role and opponent, without holding them all in memory.
+func (db *DB) StreamMatchups(ctx context.Context, filter ExportFilter, fn func(ExportRow) error) error {
+	defer observeQuery("StreamMatchups", time.Now())
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT m.patch, champ.name, c.name, m.role, m.win_rate, m.sample_size, m.scraped_at
+		FROM matchups m
+		JOIN champions champ ON m.champion_id = champ.id
+		JOIN champions c ON m.opponent_id = c.id
+		WHERE m.patch = $1 AND ($2 = '' OR LOWER(m.role) = LOWER($2))
+		ORDER BY champ.name, m.role, c.name
+	`, filter.Patch, filter.Role)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r ExportRow
+		if err := rows.Scan(&r.Patch, &r.Champion, &r.Opponent, &r.Role, &r.WinRate, &r.SampleSize, &r.ScrapedAt); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// exportWriter writes export rows in one format. Close must be called to
+// write out anything still buffered.
+type exportWriter interface {
+	Write(ExportRow) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportColumns); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{w: cw}, nil
+	case "jsonl":
+		return &jsonlExportWriter{enc: json.NewEncoder(w)}, nil
+	case "parquet":
+		return &parquetExportWriter{w: parquet.NewGenericWriter[ExportRow](w)}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q, expected csv, jsonl or parquet", format)
+}
+
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (e *csvExportWriter) Write(r ExportRow) error {
+	return e.w.Write([]string{
+		r.Patch, r.Champion, r.Opponent, r.Role,
+		strconv.FormatFloat(r.WinRate, 'f', -1, 64),
+		strconv.FormatInt(r.SampleSize, 10),
+		r.ScrapedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+func (e *csvExportWriter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type jsonlExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExportWriter) Write(r ExportRow) error {
+	return e.enc.Encode(r)
+}
+
+func (e *jsonlExportWriter) Close() error {
+	return nil
+}
+
+type parquetExportWriter struct {
+	w        *parquet.GenericWriter[ExportRow]
+	buffered int
+}
+
+func (e *parquetExportWriter) Write(r ExportRow) error {
+	if _, err := e.w.Write([]ExportRow{r}); err != nil {
+		return err
+	}
+	e.buffered++
+	if e.buffered < exportRowGroupSize {
+		return nil
+	}
+	e.buffered = 0
+	return e.w.Flush()
+}
+
+func (e *parquetExportWriter) Close() error {
+	return e.w.Close()
+}
+
+// ExportMatchups writes every matchup matching filter to w in format. It
+// returns how many rows it wrote.
+func ExportMatchups(ctx context.Context, db *DB, filter ExportFilter, format string, w io.Writer) (int, error) {
+	ew, err := newExportWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	err = db.StreamMatchups(ctx, filter, func(r ExportRow) error {
+		n++
+		return ew.Write(r)
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, ew.Close()
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// readinessTimeout bounds how long a single readiness check may take, so that
+// a hung database makes /readyz fail rather than hang.
+const readinessTimeout = 2 * time.Second
+
+type HealthCheck struct {
+	OK     bool
+	Detail string `json:",omitempty"`
+}
+
+type Readiness struct {
+	Ready    bool
+	Database HealthCheck
+	Patch    HealthCheck
+	// Scraper checks that the scraper leader is alive, and Jobs that the
+	// job it is running isn't stuck.
+	Scraper HealthCheck
+	Jobs    HealthCheck
+}
+
+// checkReadiness reports whether this instance should receive traffic: the
+// database must answer, a scraped patch must be available to serve, and, when
+// the scraper runs in this process, the scraper leader (if there is one) must
+// have renewed its lease recently and no job may be stuck. The lease is
+// renewed by a goroutine of its own, so it only shows that the leader is
+// alive; a job is stuck when it has been running for ScraperStaleAfter longer
+// than its last run took. An API-only instance still reports both but keeps
+// serving, since it can't do anything about them.
+func checkReadiness(ctx context.Context, db *DB, cfg Config) Readiness {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	var r Readiness
+
+	if err := db.PingContext(ctx); err != nil {
+		r.Database = HealthCheck{Detail: err.Error()}
+		r.Patch = HealthCheck{Detail: "database unavailable"}
+		r.Scraper = HealthCheck{Detail: "database unavailable"}
+		r.Jobs = HealthCheck{Detail: "database unavailable"}
+		return r
+	}
+	r.Database = HealthCheck{OK: true}
+
+	status, err := db.GetScrapingStatus(ctx)
+	switch {
+	case err != nil:
+		r.Patch = HealthCheck{Detail: err.Error()}
+	case status.LastScrapedPatch == "":
+		r.Patch = HealthCheck{Detail: "no patch has finished scraping yet"}
+	default:
+		r.Patch = HealthCheck{OK: true, Detail: status.LastScrapedPatch}
+	}
+
+	leader, err := db.GetScraperLeader(ctx)
+	leaderKnown := err == nil
+	switch {
+	case err != nil:
+		r.Scraper = HealthCheck{Detail: err.Error()}
+	case leader.InstanceID == "":
+		r.Scraper = HealthCheck{OK: true, Detail: "no scraper leader yet"}
+	case time.Since(leader.RenewedAt) > cfg.ScraperStaleAfter:
+		r.Scraper = HealthCheck{Detail: "scraper leader " + leader.InstanceID + " last renewed at " + leader.RenewedAt.Format(time.RFC3339)}
+	default:
+		r.Scraper = HealthCheck{OK: true, Detail: leader.InstanceID}
+	}
+
+	runs, err := db.GetJobRuns(ctx)
+	switch {
+	case err != nil:
+		r.Jobs = HealthCheck{Detail: err.Error()}
+	case !leaderKnown:
+		r.Jobs = HealthCheck{Detail: "scraper leader unknown"}
+	default:
+		r.Jobs = checkJobs(runs, leader, cfg.ScraperStaleAfter)
+	}
+
+	r.Ready = r.Database.OK && r.Patch.OK && ((r.Scraper.OK && r.Jobs.OK) || !cfg.RunsScraper)
+	return r
+}
+
+// checkJobs fails when a job has been running for staleAfter longer than its
+// last run took. A job that never finished a run has nothing to be compared
+// with, so it isn't taken to be stuck however long it runs. Runs started
+// before the current leader took over were cut short by a previous leader
+// and aren't running any more.
+func checkJobs(runs map[string]JobStatus, leader LeaderInfo, staleAfter time.Duration) HealthCheck {
+	check := HealthCheck{OK: true, Detail: "no job running"}
+	for _, name := range jobNames {
+		j := runs[name]
+		if !j.Running || leader.InstanceID == "" || j.LastStartedAt.Before(leader.AcquiredAt) {
+			continue
+		}
+		running := time.Since(*j.LastStartedAt).Round(time.Second)
+		if j.LastDurationMs == nil {
+			check.Detail = fmt.Sprintf("%s running for %s, its first run", name, running)
+			continue
+		}
+		last := time.Duration(*j.LastDurationMs) * time.Millisecond
+		if running > last+staleAfter {
+			return HealthCheck{Detail: fmt.Sprintf("%s running for %s, its last run took %s", name, running, last.Round(time.Second))}
+		}
+		check.Detail = fmt.Sprintf("%s running for %s", name, running)
+	}
+	return check
+}
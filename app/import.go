@@ -0,0 +1,266 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// importBatchSize is how many matchups of one champion and role an import
+// saves in a single transaction.
+const importBatchSize = 500
+
+// maxImportBytes bounds the body of an import request.
+const maxImportBytes = 64 << 20
+
+// errInvalidImport wraps the errors due to the imported file rather than
+// the database.
+var errInvalidImport = errors.New("invalid import")
+
+// importReader reads export rows in one format. Next returns io.EOF after
+// the last row.
+type importReader interface {
+	Next() (ExportRow, error)
+}
+
+func newImportReader(format string, r io.Reader) (importReader, error) {
+	switch format {
+	case "csv":
+		cr := csv.NewReader(r)
+		header, err := cr.Read()
+		if err == io.EOF {
+			return nil, fmt.Errorf("empty CSV, expected a header")
+		}
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]int, len(header))
+		for i, name := range header {
+			columns[strings.TrimSpace(name)] = i
+		}
+		for _, name := range exportColumns {
+			// ScrapedAt is optional: hand-written files can leave it out.
+			if _, ok := columns[name]; !ok && name != "ScrapedAt" {
+				return nil, fmt.Errorf("CSV header is missing column %s", name)
+			}
+		}
+		return &csvImportReader{r: cr, columns: columns}, nil
+	case "jsonl":
+		return &jsonlImportReader{dec: json.NewDecoder(r)}, nil
+	}
+	return nil, fmt.Errorf("unknown import format %q, expected csv or jsonl", format)
+}
+
+type csvImportReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func (i *csvImportReader) Next() (ExportRow, error) {
+	var row ExportRow
+	record, err := i.r.Read()
+	if err != nil {
+		return row, err
+	}
+	field := func(name string) string {
+		if c, ok := i.columns[name]; ok && c < len(record) {
+			return strings.TrimSpace(record[c])
+		}
+		return ""
+	}
+
+	row.Patch, row.Champion, row.Opponent, row.Role = field("Patch"), field("Champion"), field("Opponent"), field("Role")
+	if row.WinRate, err = strconv.ParseFloat(field("WinRate"), 64); err != nil {
+		return row, fmt.Errorf("invalid WinRate %q", field("WinRate"))
+	}
+	if row.SampleSize, err = strconv.ParseInt(field("SampleSize"), 10, 64); err != nil {
+		return row, fmt.Errorf("invalid SampleSize %q", field("SampleSize"))
+	}
+	if s := field("ScrapedAt"); s != "" {
+		if row.ScrapedAt, err = time.Parse(time.RFC3339, s); err != nil {
+			return row, fmt.Errorf("invalid ScrapedAt %q", s)
+		}
+	}
+	return row, nil
+}
+
+type jsonlImportReader struct {
+	dec *json.Decoder
+}
+
+func (i *jsonlImportReader) Next() (ExportRow, error) {
+	var row ExportRow
+	err := i.dec.Decode(&row)
+	return row, err
+}
+
+// validateImportRow checks a row before anything of it is saved.
+func validateImportRow(row ExportRow) error {
+	switch {
+	case row.Champion == "" || row.Opponent == "":
+		return fmt.Errorf("champion and opponent are required")
+	case row.Role == "":
+		return fmt.Errorf("role is required")
+	case row.WinRate < 0 || row.WinRate > 100:
+		return fmt.Errorf("win rate %v is not a percentage", row.WinRate)
+	case row.SampleSize < 0:
+		return fmt.Errorf("sample size %d is negative", row.SampleSize)
+	}
+	return nil
+}
+
+// importBatch is a run of rows sharing a patch, champion and role, which
+// SaveMatchups saves together.
+type importBatch struct {
+	patch    string
+	champion string
+	role     string
+	matchups []Matchup
+}
+
+// saveImportedPatch records a patch known only from an import. Unlike
+// SavePatch it leaves the release date and first-seen time unset, since
+// nothing says when the patch came out or when the scraper would have seen
+// it.
+func (db *DB) saveImportedPatch(ctx context.Context, v PatchVersion) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO patches (version, major, minor, season)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (version) DO NOTHING
+	`, v.String(), v.Major, v.Minor, v.Season())
+	return err
+}
+
+// ImportMatchups loads matchups in the export format from r, saving their
+// patches, champions and matchups the way a scrape does. Rows are saved in
+// batches as they are read, so an import that fails part way keeps the
+// batches before the bad row. If no patch is served yet, the newest imported
+// one is served; if the served patch was imported into, API instances are
+// told to drop their caches.
+func ImportMatchups(ctx context.Context, db *DB, format string, r io.Reader) (ImportReport, error) {
+	report := ImportReport{Patches: []string{}}
+	ir, err := newImportReader(format, r)
+	if err != nil {
+		return report, fmt.Errorf("%w: %v", errInvalidImport, err)
+	}
+
+	patches := make(map[string]PatchVersion)
+	// Champions are looked up ignoring case, like every read does, so that
+	// "darius" in a file doesn't add a second Darius. Only names that are
+	// genuinely new are saved, as written in the file.
+	champions := make(map[string]string)
+	resolve := func(name string) (string, error) {
+		key := strings.ToLower(name)
+		if resolved, ok := champions[key]; ok {
+			return resolved, nil
+		}
+		existing, err := db.GetChampion(ctx, name)
+		switch {
+		case err == sql.ErrNoRows:
+			if err := db.SaveChampion(ctx, Champion{Name: name}); err != nil {
+				return "", fmt.Errorf("error saving champion %s: %v", name, err)
+			}
+			existing.Name = name
+		case err != nil:
+			return "", fmt.Errorf("error getting champion %s: %v", name, err)
+		}
+		champions[key] = existing.Name
+		report.Champions++
+		return existing.Name, nil
+	}
+	var batch importBatch
+	flush := func() error {
+		if len(batch.matchups) == 0 {
+			return nil
+		}
+		if err := db.SaveMatchups(ctx, batch.champion, batch.role, batch.matchups, batch.patch); err != nil {
+			return fmt.Errorf("error saving matchups of %s %s on %s: %v", batch.champion, batch.role, batch.patch, err)
+		}
+		report.Matchups += len(batch.matchups)
+		batch.matchups = batch.matchups[:0]
+		return nil
+	}
+
+	for n := 1; ; n++ {
+		row, err := ir.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return report, fmt.Errorf("%w: row %d: %v", errInvalidImport, n, err)
+		}
+		if err := validateImportRow(row); err != nil {
+			return report, fmt.Errorf("%w: row %d: %v", errInvalidImport, n, err)
+		}
+
+		v, err := ParsePatchVersion(row.Patch)
+		if err != nil {
+			return report, fmt.Errorf("%w: row %d: %v", errInvalidImport, n, err)
+		}
+		patch := v.String()
+		if _, ok := patches[patch]; !ok {
+			if err := db.saveImportedPatch(ctx, v); err != nil {
+				return report, fmt.Errorf("error saving patch %s: %v", patch, err)
+			}
+			patches[patch] = v
+			report.Patches = append(report.Patches, patch)
+		}
+		if row.Champion, err = resolve(row.Champion); err != nil {
+			return report, err
+		}
+		if row.Opponent, err = resolve(row.Opponent); err != nil {
+			return report, err
+		}
+
+		if patch != batch.patch || row.Champion != batch.champion || row.Role != batch.role || len(batch.matchups) >= importBatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+			batch.patch, batch.champion, batch.role = patch, row.Champion, row.Role
+		}
+		batch.matchups = append(batch.matchups, Matchup{
+			Champion:   row.Opponent,
+			WinRate:    strconv.FormatFloat(row.WinRate, 'f', -1, 64),
+			SampleSize: strconv.FormatInt(row.SampleSize, 10),
+			ScrapedAt:  row.ScrapedAt,
+		})
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	if len(patches) == 0 {
+		return report, nil
+	}
+
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error getting scraping status: %v", err)
+	}
+	served := servedPatchOf(status)
+	if served == "" {
+		var newest PatchVersion
+		for _, v := range patches {
+			if v.After(newest) {
+				newest = v
+			}
+		}
+		report.Served = newest.String()
+		if err := db.UpdateScrapingStatus(ctx, ScrapingStatus{CurrentPatch: report.Served, LastScrapedPatch: report.Served}); err != nil {
+			return report, fmt.Errorf("error serving patch %s: %v", report.Served, err)
+		}
+		loggerFrom(ctx).Info("Serving imported patch", "patch", report.Served)
+	} else if _, ok := patches[served]; ok {
+		if _, err := db.MarkRefreshed(ctx); err != nil {
+			return report, fmt.Errorf("error marking served patch refreshed: %v", err)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock. It is held on a
+// dedicated connection so that it is released automatically if the process
+// dies and the connection drops.
+type AdvisoryLock struct {
+	conn *sql.Conn
+	key  int64
+}
+
+// TryAdvisoryLock attempts to take the advisory lock identified by key without
+// blocking. It returns a nil lock and no error when another session already
+// holds it.
+func (db *DB) TryAdvisoryLock(key int64) (*AdvisoryLock, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var acquired bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&acquired); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if !acquired {
+		conn.Close()
+		return nil, nil
+	}
+
+	return &AdvisoryLock{conn: conn, key: key}, nil
+}
+
+func (l *AdvisoryLock) Release() error {
+	defer l.conn.Close()
+	_, err := l.conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", l.key)
+	return err
+}
+
+// Lease is leadership backed by an advisory lock. While it is held, a
+// background goroutine renews it by writing a heartbeat to scraper_leader
+// over the lock's own connection; if that fails the lock can no longer be
+// trusted and the lease is marked lost so another instance can take over.
+type Lease struct {
+	lock       *AdvisoryLock
+	instanceID string
+	lost       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	once       sync.Once
+}
+
+// AcquireLease tries to become leader for key. It returns a nil lease and no
+// error when another instance is already the leader.
+func (db *DB) AcquireLease(key int64, instanceID string, renewEvery time.Duration) (*Lease, error) {
+	lock, err := db.TryAdvisoryLock(key)
+	if err != nil || lock == nil {
+		return nil, err
+	}
+
+	_, err = lock.conn.ExecContext(context.Background(), `
+		INSERT INTO scraper_leader (id, instance_id, acquired_at, renewed_at)
+		VALUES (1, $1, NOW(), NOW())
+		ON CONFLICT (id) DO UPDATE
+		SET instance_id = $1, acquired_at = NOW(), renewed_at = NOW()
+	`, instanceID)
+	if err != nil {
+		lock.Release()
+		return nil, err
+	}
+
+	l := &Lease{
+		lock:       lock,
+		instanceID: instanceID,
+		lost:       make(chan struct{}),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go l.renew(renewEvery)
+	return l, nil
+}
+
+func (l *Lease) renew(every time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), every)
+			_, err := l.lock.conn.ExecContext(ctx, `
+				UPDATE scraper_leader SET renewed_at = NOW()
+				WHERE id = 1 AND instance_id = $1
+			`, l.instanceID)
+			cancel()
+			if err != nil {
+				slog.Error("Error renewing scraper lease, giving up leadership", "instance_id", l.instanceID, "error", err)
+				l.markLost()
+				return
+			}
+		}
+	}
+}
+
+func (l *Lease) markLost() {
+	l.once.Do(func() { close(l.lost) })
+}
+
+// Lost is closed once the lease has been lost or released.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lease and gives up the underlying lock.
+func (l *Lease) Release() error {
+	close(l.stop)
+	<-l.done
+	l.markLost()
+	return l.lock.Release()
+}
+
+// instanceID identifies this process in the scraper_leader table.
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
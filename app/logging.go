@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type loggerKey struct{}
+
+// SetupLogging installs a JSON slog logger at the configured level as the
+// process default. The standard log package is routed through it as well.
+func SetupLogging(cfg Config) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return fmt.Errorf("invalid log level %q: %v", cfg.LogLevel, err)
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+	return nil
+}
+
+// withLogger returns a context carrying logger, so that code further down the
+// call chain logs with the same request or cycle attributes.
+func withLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// loggerFrom returns the logger stored in ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestLogger assigns every request an ID, taken from X-Request-ID when the
+// client or proxy supplies one, attaches a logger carrying it to the request
+// context and writes one access log line per request.
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader("X-Request-ID")
+		if requestID == "" || len(requestID) > 64 || strings.ContainsAny(requestID, "\r\n") {
+			requestID = newID()
+		}
+		c.Header("X-Request-ID", requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		c.Request = c.Request.WithContext(withLogger(c.Request.Context(), logger))
+
+		start := time.Now()
+		c.Next()
+
+		logger.Info("Request handled",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		)
+	}
+}
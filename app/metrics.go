@@ -0,0 +1,144 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pickhelper_http_requests_total",
+		Help: "HTTP requests served, by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pickhelper_http_request_duration_seconds",
+		Help:    "HTTP request latency, by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pickhelper_db_query_duration_seconds",
+		Help:    "Duration of database queries, by query.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"query"})
+
+	scrapeCycleDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "pickhelper_scrape_cycle_duration_seconds",
+		Help:    "Duration of full scrapes of a new patch.",
+		Buckets: prometheus.ExponentialBuckets(60, 2, 10),
+	})
+
+	jobRuns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pickhelper_job_runs_total",
+		Help: "Scheduled job runs, by job and result (success or failure).",
+	}, []string{"job", "result"})
+
+	championScrapes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pickhelper_champion_scrapes_total",
+		Help: "Per-champion matchup scrapes, by result (success or failure).",
+	}, []string{"result"})
+
+	pagesArchived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pickhelper_pages_archived_total",
+		Help: "Downloaded op.gg pages written to the raw page archive, by result (success or failure).",
+	}, []string{"result"})
+
+	matchupsSaved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "pickhelper_matchups_saved_total",
+		Help: "Matchup rows handed to SaveMatchups or StageMatchups without error.",
+	})
+
+	lastScrapeMatchups = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pickhelper_last_scrape_matchups",
+		Help: "Matchup rows saved by the most recent full scrape. Zero means the scrape yielded no data.",
+	})
+
+	stagedTotalSamples = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pickhelper_staged_total_samples",
+		Help: "Sum of sample sizes staged for the patch awaiting promotion, as of the last check.",
+	})
+
+	stagedMedianSamples = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pickhelper_staged_median_samples",
+		Help: "Median sample size staged for the patch awaiting promotion, as of the last check.",
+	})
+
+	isUpdating = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pickhelper_is_updating",
+		Help: "1 while a new patch is being scraped, 0 otherwise.",
+	})
+
+	snapshotReads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pickhelper_snapshot_reads_total",
+		Help: "API reads of the served patch, by source (snapshot or database).",
+	}, []string{"source"})
+
+	servedPatch = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "pickhelper_served_patch",
+		Help: "Always 1, labelled with the patch currently being served.",
+	}, []string{"patch"})
+)
+
+// metricsMiddleware records request counts and latency. Requests that match
+// no route are grouped under "unmatched" to keep label cardinality bounded.
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(route, c.Request.Method, status).Inc()
+		httpDuration.WithLabelValues(route, c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeQuery records how long the named query took. Use it with defer:
+//
+//	defer observeQuery("GetTopMatchups", time.Now())
+func observeQuery(name string, start time.Time) {
+	dbQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+func recordScrapingStatus(status ScrapingStatus) {
+	if status.IsUpdating {
+		isUpdating.Set(1)
+	} else {
+		isUpdating.Set(0)
+	}
+
+	patch := servedPatchOf(status)
+	servedPatch.Reset()
+	if patch != "" {
+		servedPatch.WithLabelValues(patch).Set(1)
+	}
+}
+
+// ServeMetrics exposes /metrics and /healthz on their own listener, for
+// processes such as the standalone scraper that don't run the API router.
+func ServeMetrics(ctx context.Context, cfg Config) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", cfg.Port), Handler: mux}
+	if err := serveUntilDone(ctx, srv, cfg.ShutdownTimeout); err != nil {
+		slog.Error("Metrics server stopped", "error", err)
+	}
+}
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS scraping_status;
+DROP TABLE IF EXISTS matchups;
+DROP TABLE IF EXISTS champions;
+DROP TABLE IF EXISTS patches;
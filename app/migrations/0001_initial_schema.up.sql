@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS patches (
+	version TEXT PRIMARY KEY
+);
+
+CREATE TABLE IF NOT EXISTS champions (
+	id SERIAL PRIMARY KEY,
+	name TEXT UNIQUE NOT NULL,
+	avatar_url TEXT
+);
+
+CREATE TABLE IF NOT EXISTS matchups (
+	id SERIAL PRIMARY KEY,
+	champion_id INT REFERENCES champions(id),
+	opponent_id INT REFERENCES champions(id),
+	role TEXT NOT NULL,
+	win_rate FLOAT NOT NULL,
+	sample_size INT NOT NULL,
+	patch TEXT REFERENCES patches(version),
+	UNIQUE(champion_id, opponent_id, role, patch)
+);
+
+CREATE TABLE IF NOT EXISTS scraping_status (
+	id INT PRIMARY KEY DEFAULT 1,
+	current_patch TEXT REFERENCES patches(version),
+	last_scraped_patch TEXT REFERENCES patches(version),
+	is_updating BOOLEAN NOT NULL DEFAULT false,
+	CHECK (id = 1)
+);
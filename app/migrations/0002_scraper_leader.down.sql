@@ -0,0 +1 @@
+DROP TABLE IF EXISTS scraper_leader;
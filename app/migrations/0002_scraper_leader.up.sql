@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS scraper_leader (
+	id INT PRIMARY KEY DEFAULT 1,
+	instance_id TEXT NOT NULL,
+	acquired_at TIMESTAMPTZ NOT NULL,
+	renewed_at TIMESTAMPTZ NOT NULL,
+	CHECK (id = 1)
+);
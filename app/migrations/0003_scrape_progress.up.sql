@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS scrape_progress (
+	patch TEXT NOT NULL REFERENCES patches(version),
+	champion TEXT NOT NULL,
+	scraped_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (patch, champion)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS patch_promotions;
+DROP TABLE IF EXISTS matchups_staging;
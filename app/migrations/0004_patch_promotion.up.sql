@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS matchups_staging (
+	champion_id INT NOT NULL REFERENCES champions(id),
+	opponent_id INT NOT NULL REFERENCES champions(id),
+	role TEXT NOT NULL,
+	win_rate FLOAT NOT NULL,
+	sample_size INT NOT NULL,
+	patch TEXT NOT NULL REFERENCES patches(version),
+	UNIQUE(champion_id, opponent_id, role, patch)
+);
+
+CREATE TABLE IF NOT EXISTS patch_promotions (
+	id SERIAL PRIMARY KEY,
+	action TEXT NOT NULL CHECK (action IN ('promote', 'rollback')),
+	patch TEXT NOT NULL REFERENCES patches(version),
+	previous_patch TEXT REFERENCES patches(version),
+	performed_by TEXT NOT NULL,
+	reason TEXT NOT NULL,
+	performed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
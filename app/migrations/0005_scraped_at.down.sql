@@ -0,0 +1,3 @@
+ALTER TABLE scraping_status DROP COLUMN IF EXISTS refreshed_at;
+ALTER TABLE matchups_staging DROP COLUMN IF EXISTS scraped_at;
+ALTER TABLE matchups DROP COLUMN IF EXISTS scraped_at;
@@ -0,0 +1,3 @@
+ALTER TABLE matchups ADD COLUMN IF NOT EXISTS scraped_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE matchups_staging ADD COLUMN IF NOT EXISTS scraped_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE scraping_status ADD COLUMN IF NOT EXISTS refreshed_at TIMESTAMPTZ;
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS scheduled_jobs (
+	name TEXT PRIMARY KEY,
+	last_started_at TIMESTAMPTZ,
+	last_finished_at TIMESTAMPTZ,
+	last_error TEXT
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS pruned_patches;
+DROP TABLE IF EXISTS season_matchups;
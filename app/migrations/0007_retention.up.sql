@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS season_matchups (
+	season INT NOT NULL,
+	champion_id INT NOT NULL REFERENCES champions(id),
+	opponent_id INT NOT NULL REFERENCES champions(id),
+	role TEXT NOT NULL,
+	win_rate FLOAT NOT NULL,
+	sample_size BIGINT NOT NULL,
+	patches INT NOT NULL DEFAULT 1,
+	PRIMARY KEY (season, champion_id, opponent_id, role)
+);
+
+CREATE TABLE IF NOT EXISTS pruned_patches (
+	version TEXT PRIMARY KEY,
+	season INT NOT NULL,
+	matchups BIGINT NOT NULL,
+	pruned_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS patches_major_minor_idx;
+ALTER TABLE patches DROP COLUMN IF EXISTS minor;
+ALTER TABLE patches DROP COLUMN IF EXISTS major;
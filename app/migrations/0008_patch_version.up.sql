@@ -0,0 +1,8 @@
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS major INT;
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS minor INT;
+
+UPDATE patches
+SET major = (regexp_match(version, '^(\d+)\.(?:S\d+\.)?(\d+)'))[1]::INT,
+	minor = (regexp_match(version, '^(\d+)\.(?:S\d+\.)?(\d+)'))[2]::INT;
+
+CREATE INDEX IF NOT EXISTS patches_major_minor_idx ON patches (major DESC, minor DESC);
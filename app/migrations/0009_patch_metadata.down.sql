@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS patches_season_idx;
+ALTER TABLE patches DROP COLUMN IF EXISTS promoted_at;
+ALTER TABLE patches DROP COLUMN IF EXISTS first_seen_at;
+ALTER TABLE patches DROP COLUMN IF EXISTS released_at;
+ALTER TABLE patches DROP COLUMN IF EXISTS split;
+ALTER TABLE patches DROP COLUMN IF EXISTS season;
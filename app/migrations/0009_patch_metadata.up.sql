@@ -0,0 +1,16 @@
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS season INT;
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS split INT;
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS released_at DATE;
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS first_seen_at TIMESTAMPTZ;
+ALTER TABLE patches ADD COLUMN IF NOT EXISTS promoted_at TIMESTAMPTZ;
+
+UPDATE patches SET season = major, split = 1 WHERE major IS NOT NULL;
+
+UPDATE patches p
+SET promoted_at = (
+	SELECT MIN(performed_at)
+	FROM patch_promotions
+	WHERE action = 'promote' AND patch = p.version
+);
+
+CREATE INDEX IF NOT EXISTS patches_season_idx ON patches (season, split);
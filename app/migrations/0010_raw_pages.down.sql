@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS raw_pages_lookup_idx;
+DROP TABLE IF EXISTS raw_pages;
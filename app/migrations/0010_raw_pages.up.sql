@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS raw_pages (
+	id BIGSERIAL PRIMARY KEY,
+	patch TEXT NOT NULL,
+	kind TEXT NOT NULL,
+	champion TEXT NOT NULL DEFAULT '',
+	role TEXT NOT NULL DEFAULT '',
+	fetched_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	sha256 TEXT NOT NULL,
+	size INT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS raw_pages_lookup_idx ON raw_pages (patch, kind, champion, role, fetched_at DESC);
@@ -0,0 +1 @@
+ALTER TABLE scheduled_jobs DROP COLUMN IF EXISTS last_duration_ms;
@@ -0,0 +1 @@
+ALTER TABLE scheduled_jobs ADD COLUMN IF NOT EXISTS last_duration_ms BIGINT;
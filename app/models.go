@@ -0,0 +1,166 @@
+package app
+
+import "time"
+
+type Champion struct {
+	Name      string
+	AvatarURL string
+}
+
+type Matchup struct {
+	Champion   string
+	WinRate    string
+	SampleSize string
+	ScrapedAt  time.Time
+	// Patches breaks a matchup combined over several patches down by
+	// patch, when asked for.
+	Patches []PatchMatchup `json:",omitempty"`
+}
+
+type PatchMatchup struct {
+	Patch      string
+	WinRate    string
+	SampleSize string
+}
+
+type PatchInfo struct {
+	Version string
+	Season  int
+	// Split is the split of the season, starting at 1.
+	Split int
+	// ReleasedAt defaults to the day the scraper first saw the patch.
+	ReleasedAt  *time.Time `json:",omitempty"`
+	FirstSeenAt *time.Time `json:",omitempty"`
+	// PromotedAt is when the patch was first served, or nil if it never
+	// was.
+	PromotedAt *time.Time `json:",omitempty"`
+}
+
+type ScrapingStatus struct {
+	CurrentPatch     string
+	LastScrapedPatch string
+	IsUpdating       bool
+	// RefreshedAt is when the served patch was last refreshed in place, or
+	// nil if it hasn't been since it was promoted.
+	RefreshedAt *time.Time `json:",omitempty"`
+}
+
+type MatchupLookup struct {
+	Champion string
+	Role     string
+	Opponent string
+}
+
+type MatchupLookupResult struct {
+	Champion string
+	Role     string
+	Opponent string
+	Matchups []Matchup
+}
+
+type HeadToHead struct {
+	Champion     string
+	Opponent     string
+	Role         string
+	ChampionView *Matchup
+	OpponentView *Matchup
+	Discrepancy  string
+	Disagree     bool
+}
+
+type LeaderInfo struct {
+	InstanceID string
+	AcquiredAt time.Time
+	RenewedAt  time.Time
+}
+
+type StagingReport struct {
+	Patch          string
+	Matchups       int
+	Champions      int
+	Invalid        int
+	TotalSamples   int64
+	MedianSamples  float64
+	ServedPatch    string `json:",omitempty"`
+	ServedMatchups int
+	Problems       []string
+}
+
+// ImportReport sums up an import: how many matchups it saved, how many
+// distinct champions they name and which patches they are on.
+type ImportReport struct {
+	Matchups  int
+	Champions int
+	Patches   []string
+	// Served is the imported patch that became the served one, if none
+	// was served before.
+	Served string `json:",omitempty"`
+}
+
+// RawPage is an op.gg page as downloaded by the scraper, archived under the
+// SHA-256 of its contents. Champion and Role are empty for the champions
+// page.
+type RawPage struct {
+	ID        int64
+	Patch     string
+	Kind      string
+	Champion  string
+	Role      string
+	FetchedAt time.Time
+	SHA256    string
+	Size      int
+}
+
+// ReparseReport sums up rebuilding a patch's matchups from archived pages.
+// Failed lists the pages that couldn't be read or held no matchups.
+type ReparseReport struct {
+	DryRun    bool
+	Patch     string
+	Staged    bool
+	Pages     int
+	Champions int
+	Matchups  int
+	Failed    []string
+}
+
+// RetentionReport lists the patches a retention run keeps and those it
+// prunes. In a dry run nothing is deleted and the counts are what would be.
+type RetentionReport struct {
+	DryRun bool
+	Keep   []string
+	Prune  []PatchPruning
+}
+
+type PatchPruning struct {
+	Patch          string
+	Season         int
+	Matchups       int64
+	StagedMatchups int64
+	ScrapeProgress int64
+	// DeletePatch is false when the promotion history still refers to the
+	// patch, in which case its row in patches is kept.
+	DeletePatch bool
+}
+
+type PatchPromotion struct {
+	ID            int
+	Action        string
+	Patch         string
+	PreviousPatch string
+	PerformedBy   string
+	Reason        string
+	PerformedAt   time.Time
+}
+
+type JobStatus struct {
+	Name           string
+	Schedule       string
+	Running        bool
+	LastStartedAt  *time.Time `json:",omitempty"`
+	LastFinishedAt *time.Time `json:",omitempty"`
+	LastError      string     `json:",omitempty"`
+	// LastDurationMs is how long the last finished run took.
+	LastDurationMs *int64 `json:",omitempty"`
+	// NextRunAt is nil when the job is off.
+	NextRunAt *time.Time `json:",omitempty"`
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PatchVersion is a League of Legends patch, such as 14.10. Patches must be
+// compared as versions rather than strings: 14.10 comes after 14.9.
+type PatchVersion struct {
+	Major int
+	Minor int
+}
+
+// patchVersionPattern matches the patch formats op.gg shows: "14.10",
+// "Version: 14.10", a build number such as "14.10.1", and the 2025 season
+// format "25.S1.2", which is patch 25.2.
+var patchVersionPattern = regexp.MustCompile(`^(?:[Vv]ersion:?\s*)?[Vv]?(\d+)\.(?:S\d+\.)?(\d+)(?:\.\d+)*$`)
+
+// ParsePatchVersion parses a patch as shown by op.gg or stored in patches.
+func ParsePatchVersion(s string) (PatchVersion, error) {
+	m := patchVersionPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return PatchVersion{}, fmt.Errorf("invalid patch version %q", s)
+	}
+	major, err := strconv.Atoi(m[1])
+	if err != nil {
+		return PatchVersion{}, fmt.Errorf("invalid patch version %q: %v", s, err)
+	}
+	minor, err := strconv.Atoi(m[2])
+	if err != nil {
+		return PatchVersion{}, fmt.Errorf("invalid patch version %q: %v", s, err)
+	}
+	return PatchVersion{Major: major, Minor: minor}, nil
+}
+
+// String returns the canonical form of the patch, which is how it is stored.
+func (v PatchVersion) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// Compare returns -1, 0 or +1 depending on whether v comes before, is the
+// same as, or comes after o.
+func (v PatchVersion) Compare(o PatchVersion) int {
+	switch {
+	case v.Major != o.Major:
+		if v.Major < o.Major {
+			return -1
+		}
+		return 1
+	case v.Minor < o.Minor:
+		return -1
+	case v.Minor > o.Minor:
+		return 1
+	}
+	return 0
+}
+
+// After reports whether v is a later patch than o.
+func (v PatchVersion) After(o PatchVersion) bool {
+	return v.Compare(o) > 0
+}
+
+// Season returns the season the patch belongs to, which is its major version.
+func (v PatchVersion) Season() int {
+	return v.Major
+}
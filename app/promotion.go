@@ -0,0 +1,289 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// minStagedFraction is how small a staged patch may be relative to the patch
+// being served before it is rejected. A sudden drop almost always means the
+// op.gg markup changed and most pages parsed to nothing.
+const minStagedFraction = 0.5
+
+// errNoRollbackTarget is returned by RollbackPatch when there is no earlier
+// promoted patch to go back to.
+var errNoRollbackTarget = errors.New("no earlier patch to roll back to")
+
+// ValidateStaged checks the staged rows of patch before they are promoted.
+// The returned report lists every problem found; an empty list means the
+// data is sound, though it may not have enough samples yet (see
+// sampleShortfall).
+func (db *DB) ValidateStaged(ctx context.Context, patch string) (StagingReport, error) {
+	defer observeQuery("ValidateStaged", time.Now())
+
+	report := StagingReport{Patch: patch, Problems: []string{}}
+	err := db.QueryRowContext(ctx, `
+		SELECT COUNT(*),
+			COUNT(DISTINCT champion_id),
+			COUNT(*) FILTER (WHERE win_rate < 0 OR win_rate > 100 OR sample_size < 0),
+			COALESCE(SUM(sample_size), 0),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY sample_size), 0)
+		FROM matchups_staging
+		WHERE patch = $1
+	`, patch).Scan(&report.Matchups, &report.Champions, &report.Invalid, &report.TotalSamples, &report.MedianSamples)
+	if err != nil {
+		return report, err
+	}
+
+	var served sql.NullString
+	err = db.QueryRowContext(ctx, "SELECT last_scraped_patch FROM scraping_status WHERE id = 1").Scan(&served)
+	if err != nil && err != sql.ErrNoRows {
+		return report, err
+	}
+	if served.Valid && served.String != patch {
+		report.ServedPatch = served.String
+		err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM matchups WHERE patch = $1", served.String).Scan(&report.ServedMatchups)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	if report.Matchups == 0 {
+		report.Problems = append(report.Problems, "no matchups were staged")
+	}
+	if report.Invalid > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("%d matchups have a win rate outside 0-100 or a negative sample size", report.Invalid))
+	}
+	if report.ServedMatchups > 0 && float64(report.Matchups) < float64(report.ServedMatchups)*minStagedFraction {
+		report.Problems = append(report.Problems, fmt.Sprintf("only %d matchups were staged, against %d for patch %s",
+			report.Matchups, report.ServedMatchups, report.ServedPatch))
+	}
+
+	return report, nil
+}
+
+// sampleShortfall reports why the staged samples of a new patch aren't yet
+// large enough to replace the patch being served. Early in a patch op.gg only
+// has a few games per matchup, and serving those would be worse than serving
+// last patch's numbers.
+func sampleShortfall(report StagingReport, cfg Config) []string {
+	var shortfall []string
+	if report.TotalSamples < int64(cfg.PromotionMinTotalSamples) {
+		shortfall = append(shortfall, fmt.Sprintf("total sample size is %d, below %d", report.TotalSamples, cfg.PromotionMinTotalSamples))
+	}
+	if report.MedianSamples < float64(cfg.PromotionMinMedianSamples) {
+		shortfall = append(shortfall, fmt.Sprintf("median sample size is %.0f, below %d", report.MedianSamples, cfg.PromotionMinMedianSamples))
+	}
+	return shortfall
+}
+
+// DiscardStaged drops the staged rows and the scrape checkpoint of patch, so
+// that the next run scrapes it again from scratch.
+func (db *DB) DiscardStaged(ctx context.Context, patch string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM matchups_staging WHERE patch = $1", patch); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM scrape_progress WHERE patch = $1", patch); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// PromotePatch moves the staged rows of patch into matchups and starts
+// serving it, in a single transaction that also records who promoted it and
+// why.
+func (db *DB) PromotePatch(ctx context.Context, patch string, by string, reason string) (PatchPromotion, error) {
+	defer observeQuery("PromotePatch", time.Now())
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return PatchPromotion{}, err
+	}
+	defer tx.Rollback()
+
+	var previous sql.NullString
+	err = tx.QueryRowContext(ctx, "SELECT last_scraped_patch FROM scraping_status WHERE id = 1 FOR UPDATE").Scan(&previous)
+	if err != nil {
+		return PatchPromotion{}, fmt.Errorf("error locking scraping status: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM matchups WHERE patch = $1", patch); err != nil {
+		return PatchPromotion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO matchups (champion_id, opponent_id, role, win_rate, sample_size, patch, scraped_at)
+		SELECT champion_id, opponent_id, role, win_rate, sample_size, patch, scraped_at
+		FROM matchups_staging
+		WHERE patch = $1
+	`, patch); err != nil {
+		return PatchPromotion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM matchups_staging WHERE patch = $1", patch); err != nil {
+		return PatchPromotion{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE patches SET promoted_at = COALESCE(promoted_at, NOW()) WHERE version = $1", patch); err != nil {
+		return PatchPromotion{}, err
+	}
+
+	status := ScrapingStatus{LastScrapedPatch: patch}
+	err = tx.QueryRowContext(ctx, `
+		UPDATE scraping_status SET last_scraped_patch = $1, is_updating = false, refreshed_at = NULL
+		WHERE id = 1
+		RETURNING current_patch
+	`, patch).Scan(&status.CurrentPatch)
+	if err != nil {
+		return PatchPromotion{}, err
+	}
+
+	p := PatchPromotion{Action: "promote", Patch: patch, PreviousPatch: previous.String, PerformedBy: by, Reason: reason}
+	if err := insertPromotion(ctx, tx, &p); err != nil {
+		return PatchPromotion{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return PatchPromotion{}, err
+	}
+	recordScrapingStatus(status)
+	return p, nil
+}
+
+// RollbackPatch re-points serving at the patch that was served before the
+// current one was promoted. Its matchups are still in the table, so nothing
+// is copied. The scraper won't promote the rolled-back patch again until it
+// is rescraped, which only happens when op.gg moves on to a newer patch.
+func (db *DB) RollbackPatch(ctx context.Context, by string, reason string) (PatchPromotion, error) {
+	defer observeQuery("RollbackPatch", time.Now())
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return PatchPromotion{}, err
+	}
+	defer tx.Rollback()
+
+	var status ScrapingStatus
+	var served sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT current_patch, last_scraped_patch, is_updating
+		FROM scraping_status
+		WHERE id = 1
+		FOR UPDATE
+	`).Scan(&status.CurrentPatch, &served, &status.IsUpdating)
+	if err == sql.ErrNoRows || (err == nil && !served.Valid) {
+		return PatchPromotion{}, errNoRollbackTarget
+	}
+	if err != nil {
+		return PatchPromotion{}, err
+	}
+
+	var target sql.NullString
+	err = tx.QueryRowContext(ctx, `
+		SELECT previous_patch
+		FROM patch_promotions
+		WHERE action = 'promote' AND patch = $1
+		ORDER BY id DESC
+		LIMIT 1
+	`, served.String).Scan(&target)
+	if err == sql.ErrNoRows || (err == nil && !target.Valid) {
+		return PatchPromotion{}, errNoRollbackTarget
+	}
+	if err != nil {
+		return PatchPromotion{}, err
+	}
+
+	var hasData bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM matchups WHERE patch = $1)", target.String).Scan(&hasData); err != nil {
+		return PatchPromotion{}, err
+	}
+	if !hasData {
+		return PatchPromotion{}, fmt.Errorf("%w: patch %s has no matchups left", errNoRollbackTarget, target.String)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE scraping_status SET last_scraped_patch = $1, refreshed_at = NULL WHERE id = 1", target.String); err != nil {
+		return PatchPromotion{}, err
+	}
+
+	p := PatchPromotion{Action: "rollback", Patch: target.String, PreviousPatch: served.String, PerformedBy: by, Reason: reason}
+	if err := insertPromotion(ctx, tx, &p); err != nil {
+		return PatchPromotion{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return PatchPromotion{}, err
+	}
+	status.LastScrapedPatch = target.String
+	recordScrapingStatus(status)
+	return p, nil
+}
+
+func insertPromotion(ctx context.Context, tx *sql.Tx, p *PatchPromotion) error {
+	var previous interface{}
+	if p.PreviousPatch != "" {
+		previous = p.PreviousPatch
+	}
+	return tx.QueryRowContext(ctx, `
+		INSERT INTO patch_promotions (action, patch, previous_patch, performed_by, reason)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, performed_at
+	`, p.Action, p.Patch, previous, p.PerformedBy, p.Reason).Scan(&p.ID, &p.PerformedAt)
+}
+
+// GetPromotions returns the most recent promotions and rollbacks, newest
+// first.
+func (db *DB) GetPromotions(ctx context.Context, limit int) ([]PatchPromotion, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, action, patch, previous_patch, performed_by, reason, performed_at
+		FROM patch_promotions
+		ORDER BY id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promotions := []PatchPromotion{}
+	for rows.Next() {
+		var p PatchPromotion
+		var previous sql.NullString
+		if err := rows.Scan(&p.ID, &p.Action, &p.Patch, &previous, &p.PerformedBy, &p.Reason, &p.PerformedAt); err != nil {
+			return nil, err
+		}
+		p.PreviousPatch = previous.String
+		promotions = append(promotions, p)
+	}
+
+	return promotions, rows.Err()
+}
+
+// PruneStaging deletes the staged rows and scrape checkpoints of every patch
+// other than keep. It returns how many rows of each it deleted.
+func (db *DB) PruneStaging(ctx context.Context, keep string) (int64, int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM matchups_staging WHERE patch <> $1", keep)
+	if err != nil {
+		return 0, 0, err
+	}
+	staged, _ := res.RowsAffected()
+	res, err = tx.ExecContext(ctx, "DELETE FROM scrape_progress WHERE patch <> $1", keep)
+	if err != nil {
+		return 0, 0, err
+	}
+	progress, _ := res.RowsAffected()
+
+	return staged, progress, tx.Commit()
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// GetStaleChampions returns the champions of patch that have matchups scraped
+// before cutoff, those with the fewest games first: their win rates move the
+// most as games come in, so they are worth refreshing before the rest.
+func (db *DB) GetStaleChampions(ctx context.Context, patch string, cutoff time.Time) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT champ.name
+		FROM matchups m
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE m.patch = $1
+		GROUP BY champ.name
+		HAVING MIN(m.scraped_at) < $2
+		ORDER BY SUM(m.sample_size), champ.name
+	`, patch, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// MarkRefreshed records that the served patch was refreshed in place, which
+// tells API instances to drop what they have cached for it.
+func (db *DB) MarkRefreshed(ctx context.Context) (time.Time, error) {
+	var refreshedAt time.Time
+	err := db.QueryRowContext(ctx, "UPDATE scraping_status SET refreshed_at = NOW() WHERE id = 1 RETURNING refreshed_at").Scan(&refreshedAt)
+	return refreshedAt, err
+}
+
+// refreshServedPatch scrapes again the champions of the served patch whose
+// data is older than cutoff, so that sample sizes and win rates keep up
+// during the patch. op.gg only shows the current patch, so this must only be
+// called while the served patch is op.gg's current one.
+func refreshServedPatch(ctx context.Context, db *DB, cfg Config, archive *PageArchive, patch string, cutoff time.Time) error {
+	logger := loggerFrom(ctx)
+
+	stale, err := db.GetStaleChampions(ctx, patch, cutoff)
+	if err != nil {
+		return fmt.Errorf("error finding champions to refresh: %v", err)
+	}
+	if len(stale) == 0 {
+		logger.Info("Served patch is up to date, no refresh needed", "patch", patch)
+		return nil
+	}
+	logger.Info("Refreshing served patch", "patch", patch, "champions", len(stale))
+
+	workCtx := context.WithoutCancel(ctx)
+	record := archive.Recorder(workCtx, patch)
+	saved := 0
+	for _, name := range stale {
+		if ctx.Err() != nil {
+			logger.Warn("Refresh interrupted", "cause", context.Cause(ctx))
+			break
+		}
+		matchups, err := ScrapeMatchups(workCtx, cfg.OpGGBaseURL, name, cfg.Roles, record)
+		if err != nil {
+			logger.Error("Error scraping matchups", "champion", name, "error", err)
+			championScrapes.WithLabelValues("failure").Inc()
+			continue
+		}
+		champSaved := 0
+		for role, roleMatchups := range matchups {
+			if err := db.SaveMatchups(workCtx, name, role, roleMatchups, patch); err != nil {
+				logger.Error("Error saving matchups", "champion", name, "role", role, "error", err)
+				continue
+			}
+			champSaved += len(roleMatchups)
+		}
+		matchupsSaved.Add(float64(champSaved))
+		saved += champSaved
+		if champSaved == 0 {
+			championScrapes.WithLabelValues("failure").Inc()
+		} else {
+			championScrapes.WithLabelValues("success").Inc()
+		}
+		logger.Debug("Refreshed matchups", "champion", name, "saved", champSaved)
+		sleepContext(ctx, cfg.ScrapingDelay)
+	}
+
+	if saved == 0 {
+		return nil
+	}
+
+	if _, err := db.MarkRefreshed(workCtx); err != nil {
+		return fmt.Errorf("error recording refresh: %v", err)
+	}
+	logger.Info("Refreshed served patch", "patch", patch, "saved", saved)
+	return nil
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type ReparseFilter struct {
+	Patch string
+	// Champion and Role are optional; empty reparses every champion or
+	// role.
+	Champion string
+	Role     string
+}
+
+// PatchPromoted reports whether a patch has been promoted, meaning its
+// matchups live in matchups rather than matchups_staging. It returns
+// sql.ErrNoRows if the patch is unknown.
+func (db *DB) PatchPromoted(ctx context.Context, patch string) (bool, error) {
+	var promoted bool
+	err := db.QueryRowContext(ctx, `
+		SELECT promoted_at IS NOT NULL OR EXISTS (SELECT 1 FROM matchups WHERE patch = $1)
+		FROM patches
+		WHERE version = $1
+	`, patch).Scan(&promoted)
+	return promoted, err
+}
+
+// Reparse rebuilds the matchups of a patch from the latest archived page of
+// every champion and role, as if they had just been scraped, so that data
+// scraped with a broken selector can be repaired without scraping op.gg
+// again. Matchups are saved with the time their page was fetched. A patch
+// that hasn't been promoted yet is reparsed into staging. With dryRun set
+// pages are parsed but nothing is saved.
+func Reparse(ctx context.Context, db *DB, archive *PageArchive, filter ReparseFilter, dryRun bool) (ReparseReport, error) {
+	report := ReparseReport{DryRun: dryRun, Patch: filter.Patch, Failed: []string{}}
+	if archive == nil {
+		return report, fmt.Errorf("no page archive is configured")
+	}
+	logger := loggerFrom(ctx)
+
+	promoted, err := db.PatchPromoted(ctx, filter.Patch)
+	if err == sql.ErrNoRows {
+		return report, fmt.Errorf("unknown patch %s", filter.Patch)
+	}
+	if err != nil {
+		return report, fmt.Errorf("error getting patch %s: %v", filter.Patch, err)
+	}
+	report.Staged = !promoted
+	save := db.SaveMatchups
+	if report.Staged {
+		save = db.StageMatchups
+	}
+
+	pages, err := db.GetRawPages(ctx, filter)
+	if err != nil {
+		return report, fmt.Errorf("error listing archived pages: %v", err)
+	}
+	if len(pages) == 0 {
+		return report, fmt.Errorf("no archived pages for patch %s", filter.Patch)
+	}
+
+	// The champions page sorts first, so opponents exist before the
+	// matchups naming them are saved.
+	for _, page := range pages {
+		name := page.Kind
+		if page.Champion != "" {
+			name = page.Champion + " " + page.Role
+		}
+		body, err := archive.Load(ctx, page)
+		if err != nil {
+			logger.Error("Error loading archived page", "page", name, "error", err)
+			report.Failed = append(report.Failed, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		report.Pages++
+
+		switch page.Kind {
+		case pageChampions:
+			champions, err := parseChampions(bytes.NewReader(body))
+			if err != nil || len(champions) == 0 {
+				report.Failed = append(report.Failed, fmt.Sprintf("%s: no champions found", name))
+				continue
+			}
+			for _, champ := range champions {
+				if dryRun {
+					continue
+				}
+				if err := db.SaveChampion(ctx, champ); err != nil {
+					return report, fmt.Errorf("error saving champion %s: %v", champ.Name, err)
+				}
+			}
+			report.Champions += len(champions)
+		case pageMatchups:
+			matchups, err := parseMatchups(bytes.NewReader(body))
+			if err != nil || len(matchups) == 0 {
+				report.Failed = append(report.Failed, fmt.Sprintf("%s: no matchups found", name))
+				continue
+			}
+			for i := range matchups {
+				matchups[i].ScrapedAt = page.FetchedAt
+			}
+			if !dryRun {
+				if err := save(ctx, page.Champion, page.Role, matchups, page.Patch); err != nil {
+					return report, fmt.Errorf("error saving matchups of %s: %v", name, err)
+				}
+			}
+			report.Matchups += len(matchups)
+		}
+	}
+
+	if dryRun || report.Staged || report.Matchups == 0 {
+		return report, nil
+	}
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error getting scraping status: %v", err)
+	}
+	if servedPatchOf(status) == filter.Patch {
+		if _, err := db.MarkRefreshed(ctx); err != nil {
+			return report, fmt.Errorf("error marking served patch refreshed: %v", err)
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PlanRetention works out which patches a retention run keeps and which it
+// prunes, with how many rows pruning each one deletes. The newest keep
+// patches are kept, as are the patches op.gg and the API are on, whatever
+// their age. A patch already pruned whose row is kept for the promotion
+// history is left out, unless rows of it have appeared since.
+func (db *DB) PlanRetention(ctx context.Context, keep int) (RetentionReport, error) {
+	defer observeQuery("PlanRetention", time.Now())
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT p.version,
+			(SELECT COUNT(*) FROM matchups WHERE patch = p.version),
+			(SELECT COUNT(*) FROM matchups_staging WHERE patch = p.version),
+			(SELECT COUNT(*) FROM scrape_progress WHERE patch = p.version),
+			EXISTS (SELECT 1 FROM patch_promotions WHERE patch = p.version OR previous_patch = p.version),
+			EXISTS (SELECT 1 FROM scraping_status WHERE current_patch = p.version OR last_scraped_patch = p.version),
+			EXISTS (SELECT 1 FROM pruned_patches WHERE version = p.version)
+		FROM patches p
+		ORDER BY p.major DESC NULLS LAST, p.minor DESC NULLS LAST
+	`)
+	if err != nil {
+		return RetentionReport{}, err
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		pruning PatchPruning
+		inUse   bool
+	}
+	var patches []candidate
+	for rows.Next() {
+		var c candidate
+		var audited, pruned bool
+		if err := rows.Scan(&c.pruning.Patch, &c.pruning.Matchups, &c.pruning.StagedMatchups, &c.pruning.ScrapeProgress, &audited, &c.inUse, &pruned); err != nil {
+			return RetentionReport{}, err
+		}
+		if pruned && !c.inUse && c.pruning.Matchups == 0 && c.pruning.StagedMatchups == 0 && c.pruning.ScrapeProgress == 0 {
+			continue
+		}
+		c.pruning.DeletePatch = !audited
+		patches = append(patches, c)
+	}
+	if err := rows.Err(); err != nil {
+		return RetentionReport{}, err
+	}
+
+	report := RetentionReport{Keep: []string{}, Prune: []PatchPruning{}}
+	for i, c := range patches {
+		v, err := ParsePatchVersion(c.pruning.Patch)
+		// A patch that can't be placed in a season can't be summarized, so
+		// it is kept rather than lost.
+		if i < keep || c.inUse || err != nil {
+			report.Keep = append(report.Keep, c.pruning.Patch)
+			continue
+		}
+		c.pruning.Season = v.Season()
+		report.Prune = append(report.Prune, c.pruning)
+	}
+
+	return report, nil
+}
+
+// PrunePatch folds the matchups of a patch into its season's summary and
+// deletes them, in a single transaction. Rows referencing the patch are
+// deleted before the patch itself, which is only deleted when the promotion
+// history doesn't refer to it; the history is kept. It returns what was
+// actually deleted.
+func (db *DB) PrunePatch(ctx context.Context, patch string, season int) (PatchPruning, error) {
+	defer observeQuery("PrunePatch", time.Now())
+
+	pruned := PatchPruning{Patch: patch, Season: season}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return pruned, err
+	}
+	defer tx.Rollback()
+
+	// Win rates are combined weighted by sample size, so a season's win
+	// rate is the one over all of its games.
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO season_matchups AS s (season, champion_id, opponent_id, role, win_rate, sample_size)
+		SELECT $2, champion_id, opponent_id, role, win_rate, sample_size
+		FROM matchups
+		WHERE patch = $1 AND champion_id IS NOT NULL AND opponent_id IS NOT NULL
+		ON CONFLICT (season, champion_id, opponent_id, role) DO UPDATE SET
+			win_rate = CASE WHEN s.sample_size + EXCLUDED.sample_size = 0 THEN EXCLUDED.win_rate
+				ELSE (s.win_rate * s.sample_size + EXCLUDED.win_rate * EXCLUDED.sample_size) / (s.sample_size + EXCLUDED.sample_size)
+			END,
+			sample_size = s.sample_size + EXCLUDED.sample_size,
+			patches = s.patches + 1
+	`, patch, season); err != nil {
+		return pruned, fmt.Errorf("error summarizing patch %s: %v", patch, err)
+	}
+
+	deletes := []struct {
+		query string
+		dst   *int64
+	}{
+		{"DELETE FROM matchups WHERE patch = $1", &pruned.Matchups},
+		{"DELETE FROM matchups_staging WHERE patch = $1", &pruned.StagedMatchups},
+		{"DELETE FROM scrape_progress WHERE patch = $1", &pruned.ScrapeProgress},
+	}
+	for _, d := range deletes {
+		res, err := tx.ExecContext(ctx, d.query, patch)
+		if err != nil {
+			return pruned, err
+		}
+		if *d.dst, err = res.RowsAffected(); err != nil {
+			return pruned, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO pruned_patches (version, season, matchups)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (version) DO UPDATE SET matchups = pruned_patches.matchups + EXCLUDED.matchups, pruned_at = NOW()
+	`, patch, season, pruned.Matchups); err != nil {
+		return pruned, err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		DELETE FROM patches
+		WHERE version = $1
+			AND NOT EXISTS (SELECT 1 FROM patch_promotions WHERE patch = $1 OR previous_patch = $1)
+			AND NOT EXISTS (SELECT 1 FROM scraping_status WHERE current_patch = $1 OR last_scraped_patch = $1)
+	`, patch)
+	if err != nil {
+		return pruned, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return pruned, err
+	}
+	pruned.DeletePatch = deleted > 0
+
+	return pruned, tx.Commit()
+}
+
+// ApplyRetention prunes every patch but the newest keep ones, unless dryRun
+// is set, in which case it only reports what it would prune.
+func ApplyRetention(ctx context.Context, db *DB, keep int, dryRun bool) (RetentionReport, error) {
+	report, err := db.PlanRetention(ctx, keep)
+	if err != nil {
+		return report, fmt.Errorf("error planning retention: %v", err)
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return report, nil
+	}
+
+	logger := loggerFrom(ctx)
+	for i, p := range report.Prune {
+		pruned, err := db.PrunePatch(ctx, p.Patch, p.Season)
+		if err != nil {
+			return report, fmt.Errorf("error pruning patch %s: %v", p.Patch, err)
+		}
+		report.Prune[i] = pruned
+		logger.Info("Pruned patch", "patch", pruned.Patch, "season", pruned.Season, "matchups", pruned.Matchups,
+			"staged_matchups", pruned.StagedMatchups, "scrape_progress", pruned.ScrapeProgress, "patch_deleted", pruned.DeletePatch)
+	}
+
+	return report, nil
+}
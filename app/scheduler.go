@@ -0,0 +1,263 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// jobNames lists the scraper's scheduled jobs, in the order they run when
+// several are due at once.
+var jobNames = []string{"patch-check", "full-scrape", "refresh", "cleanup", "retention"}
+
+// scheduleOff disables a job in Config.Schedules.
+const scheduleOff = "off"
+
+// JobFunc runs one scheduled job. lastStarted is when the previous run of the
+// job started, or the zero time if it never ran.
+type JobFunc func(ctx context.Context, lastStarted time.Time) error
+
+// parseSchedule parses a standard five-field cron expression or a descriptor
+// such as @hourly or "@every 30m". A nil schedule means the job is off.
+func parseSchedule(spec string) (cron.Schedule, error) {
+	if spec == scheduleOff {
+		return nil, nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule cron.Schedule
+	run      JobFunc
+}
+
+// scheduler runs the scraper's jobs on their cron schedules, one at a time,
+// so that they never hit op.gg concurrently. Job runs are recorded in the
+// scheduled_jobs table: a run that was due while no scheduler was running is
+// made up for as soon as one starts, and the API can show when each job runs
+// next.
+type scheduler struct {
+	db        *DB
+	cfg       Config
+	jobs      []*scheduledJob
+	triggered chan string
+}
+
+func newScheduler(db *DB, cfg Config) *scheduler {
+	return &scheduler{db: db, cfg: cfg, triggered: make(chan string, len(jobNames))}
+}
+
+// Add registers the job called name. Jobs whose schedule is off are not run.
+func (s *scheduler) Add(name string, run JobFunc) error {
+	spec := s.cfg.Schedules[name]
+	schedule, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule %q for job %s: %v", spec, name, err)
+	}
+	if schedule == nil {
+		return nil
+	}
+	s.jobs = append(s.jobs, &scheduledJob{name: name, spec: spec, schedule: schedule, run: run})
+	return nil
+}
+
+// Trigger makes the named job run as soon as the current one finishes,
+// regardless of its schedule.
+func (s *scheduler) Trigger(name string) {
+	select {
+	case s.triggered <- name:
+	default:
+	}
+}
+
+// Run runs due jobs until ctx is cancelled. A job interrupted by cancellation
+// finishes its current unit of work first, as the jobs check ctx themselves.
+func (s *scheduler) Run(ctx context.Context) error {
+	logger := loggerFrom(ctx)
+	if len(s.jobs) == 0 {
+		logger.Warn("All scheduled jobs are off")
+		<-ctx.Done()
+		return nil
+	}
+
+	runs, err := s.db.GetJobRuns(ctx)
+	if err != nil {
+		return fmt.Errorf("error reading job runs: %v", err)
+	}
+
+	now := time.Now()
+	next := make(map[string]time.Time, len(s.jobs))
+	lastStarted := make(map[string]time.Time, len(s.jobs))
+	for _, job := range s.jobs {
+		run := runs[job.name]
+		if run.LastStartedAt == nil {
+			// Never ran: run now rather than wait for the first slot.
+			next[job.name] = now
+			continue
+		}
+		lastStarted[job.name] = *run.LastStartedAt
+		// If one or more runs were missed while no scheduler was running,
+		// this is in the past and the job runs once straight away.
+		next[job.name] = job.schedule.Next(*run.LastStartedAt)
+	}
+
+	for ctx.Err() == nil {
+		due := s.jobs[0]
+		for _, job := range s.jobs[1:] {
+			if next[job.name].Before(next[due.name]) {
+				due = job
+			}
+		}
+
+		wait := time.Until(next[due.name])
+		if wait > 0 {
+			logger.Info("Waiting for next scheduled job", "job", due.name, "at", next[due.name].Format(time.RFC3339))
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil
+			case name := <-s.triggered:
+				timer.Stop()
+				if _, ok := next[name]; ok {
+					next[name] = time.Now()
+				}
+				continue
+			case <-timer.C:
+			}
+		}
+
+		started := time.Now()
+		s.runJob(ctx, due, lastStarted[due.name])
+		lastStarted[due.name] = started
+		next[due.name] = due.schedule.Next(time.Now())
+	}
+
+	return nil
+}
+
+func (s *scheduler) runJob(ctx context.Context, job *scheduledJob, lastStarted time.Time) {
+	logger := loggerFrom(ctx).With("job", job.name, "cycle_id", newID())
+	ctx = withLogger(ctx, logger)
+	// Run bookkeeping survives shutdown, so that an interrupted run is still
+	// recorded as finished.
+	recordCtx := context.WithoutCancel(ctx)
+
+	started := time.Now()
+	if err := s.db.RecordJobStart(recordCtx, job.name, started); err != nil {
+		logger.Error("Error recording job start", "error", err)
+	}
+
+	logger.Info("Running scheduled job")
+	err := job.run(ctx, lastStarted)
+	duration := time.Since(started)
+	if err != nil {
+		logger.Error("Scheduled job failed", "error", err, "duration_ms", duration.Milliseconds())
+		jobRuns.WithLabelValues(job.name, "failure").Inc()
+	} else {
+		logger.Info("Scheduled job finished", "duration_ms", duration.Milliseconds())
+		jobRuns.WithLabelValues(job.name, "success").Inc()
+	}
+
+	if err := s.db.RecordJobFinish(recordCtx, job.name, time.Now(), err); err != nil {
+		logger.Error("Error recording job finish", "error", err)
+	}
+}
+
+// GetJobRuns returns the recorded runs of every job that has run, by name.
+func (db *DB) GetJobRuns(ctx context.Context) (map[string]JobStatus, error) {
+	rows, err := db.QueryContext(ctx, "SELECT name, last_started_at, last_finished_at, last_error, last_duration_ms FROM scheduled_jobs")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := make(map[string]JobStatus)
+	for rows.Next() {
+		var j JobStatus
+		var started, finished sql.NullTime
+		var lastError sql.NullString
+		var duration sql.NullInt64
+		if err := rows.Scan(&j.Name, &started, &finished, &lastError, &duration); err != nil {
+			return nil, err
+		}
+		if started.Valid {
+			j.LastStartedAt = &started.Time
+		}
+		if finished.Valid {
+			j.LastFinishedAt = &finished.Time
+		}
+		j.LastError = lastError.String
+		if duration.Valid {
+			j.LastDurationMs = &duration.Int64
+		}
+		j.Running = j.LastStartedAt != nil && (j.LastFinishedAt == nil || j.LastFinishedAt.Before(*j.LastStartedAt))
+		runs[j.Name] = j
+	}
+
+	return runs, rows.Err()
+}
+
+func (db *DB) RecordJobStart(ctx context.Context, name string, at time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO scheduled_jobs (name, last_started_at)
+		VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET last_started_at = $2
+	`, name, at)
+	return err
+}
+
+func (db *DB) RecordJobFinish(ctx context.Context, name string, at time.Time, jobErr error) error {
+	var lastError interface{}
+	if jobErr != nil {
+		lastError = jobErr.Error()
+	}
+	_, err := db.ExecContext(ctx, `
+		UPDATE scheduled_jobs SET last_finished_at = $2, last_error = $3,
+			last_duration_ms = (EXTRACT(EPOCH FROM $2::TIMESTAMPTZ - last_started_at) * 1000)::BIGINT
+		WHERE name = $1
+	`, name, at, lastError)
+	return err
+}
+
+// GetSchedule reports every job's schedule, its last run and when it will
+// run next, for the API. It can be called from any process.
+func GetSchedule(ctx context.Context, db *DB, cfg Config) ([]JobStatus, error) {
+	runs, err := db.GetJobRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	jobs := make([]JobStatus, 0, len(jobNames))
+	for _, name := range jobNames {
+		j := runs[name]
+		j.Name = name
+		j.Schedule = cfg.Schedules[name]
+
+		schedule, err := parseSchedule(j.Schedule)
+		if err != nil {
+			slog.Warn("Invalid job schedule", "job", name, "schedule", j.Schedule, "error", err)
+		}
+		if schedule != nil {
+			next := now
+			if j.LastStartedAt != nil {
+				next = schedule.Next(*j.LastStartedAt)
+			}
+			// An overdue job runs as soon as the scheduler is free.
+			if next.Before(now) {
+				next = now
+			}
+			j.NextRunAt = &next
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
@@ -0,0 +1,174 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// PageRecorder is called with every page the scraper downloads, before it is
+// parsed. A nil PageRecorder records nothing.
+type PageRecorder func(kind string, champion string, role string, body []byte)
+
+// downloadPage fetches url with wget and returns its contents. The page is
+// written to wget's standard output rather than a file, so concurrent scrapes
+// never share anything on disk.
+func downloadPage(ctx context.Context, url string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "wget", "-q", "-O", "-", url)
+	body, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error downloading page: %v", err)
+	}
+	return body, nil
+}
+
+func ScrapePatchInfo(ctx context.Context, baseURL string) (PatchInfo, error) {
+	body, err := downloadPage(ctx, baseURL+"/champions")
+	if err != nil {
+		return PatchInfo{}, err
+	}
+
+	// Parse the HTML file
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return PatchInfo{}, fmt.Errorf("error parsing HTML: %v", err)
+	}
+
+	v, err := ParsePatchVersion(doc.Find(".css-17jvkpw").Text())
+	if err != nil {
+		return PatchInfo{}, fmt.Errorf("error parsing patch version: %v", err)
+	}
+	return PatchInfo{Version: v.String()}, nil
+}
+
+func ScrapeChampions(ctx context.Context, baseURL string, record PageRecorder) ([]Champion, error) {
+	body, err := downloadPage(ctx, baseURL+"/champions")
+	if err != nil {
+		return nil, err
+	}
+	if record != nil {
+		record(pageChampions, "", "", body)
+	}
+
+	return parseChampions(bytes.NewReader(body))
+}
+
+// parseChampions reads the champion list from op.gg's champions page.
+func parseChampions(r io.Reader) ([]Champion, error) {
+	// Parse the HTML file
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing HTML: %v", err)
+	}
+
+	var champions []Champion
+
+	doc.Find(".css-1hw6gn9").Each(func(i int, s *goquery.Selection) {
+		avatarURL, _ := s.Find("img").Attr("src")
+		championName := s.Text()
+		parsedURL, _ := url.Parse(avatarURL)
+		avatarURL = fmt.Sprintf("%s://%s%s", parsedURL.Scheme, parsedURL.Host, parsedURL.Path)
+
+		champion := Champion{
+			Name:      championName,
+			AvatarURL: avatarURL,
+		}
+		champions = append(champions, champion)
+	})
+
+	return champions, nil
+}
+
+func transformChampionName(name string) string {
+	// Handle special cases
+	switch strings.ToLower(name) {
+	case "wukong":
+		return "monkeyking"
+	case "nunu & willump":
+		return "nunu"
+	}
+
+	// Convert to lowercase
+	name = strings.ToLower(name)
+
+	// Remove apostrophes
+	name = strings.ReplaceAll(name, "'", "")
+
+	// Replace spaces with empty string
+	name = strings.ReplaceAll(name, " ", "")
+
+	// Remove periods (for Dr. Mundo)
+	name = strings.ReplaceAll(name, ".", "")
+
+	// Remove any non-alphanumeric characters
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+func ScrapeMatchups(ctx context.Context, baseURL string, champName string, roles []string, record PageRecorder) (map[string][]Matchup, error) {
+	logger := loggerFrom(ctx)
+	matchups := make(map[string][]Matchup)
+
+	urlChampName := transformChampionName(champName)
+
+	for _, role := range roles {
+		url := fmt.Sprintf("%s/champions/%s/counters/%s", baseURL, urlChampName, role)
+		body, err := downloadPage(ctx, url)
+		if err != nil {
+			logger.Error("Error downloading page", "champion", champName, "role", role, "error", err)
+			continue
+		}
+		if record != nil {
+			record(pageMatchups, champName, role, body)
+		}
+
+		roleMatchups, err := parseMatchups(bytes.NewReader(body))
+		if err != nil {
+			logger.Error("Error parsing HTML", "champion", champName, "role", role, "error", err)
+			continue
+		}
+
+		matchups[role] = roleMatchups
+	}
+
+	return matchups, nil
+}
+
+// parseMatchups reads the matchups from op.gg's counters page of a champion
+// in a role.
+func parseMatchups(r io.Reader) ([]Matchup, error) {
+	// Parse the HTML file
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var roleMatchups []Matchup
+	doc.Find(".css-12a3bv1").Each(func(i int, s *goquery.Selection) {
+		opponent := s.Find(".css-72rvq0").Text()
+		winRate := s.Find(".css-ekbdas").Text()
+		sampleSize := s.Find(".css-1nfew2i").Text()
+
+		// Remove the '%' symbol from the win rate
+		winRate = strings.TrimSuffix(winRate, "%")
+
+		roleMatchups = append(roleMatchups, Matchup{
+			Champion:   opponent,
+			WinRate:    winRate,
+			SampleSize: sampleSize,
+		})
+	})
+
+	return roleMatchups, nil
+}
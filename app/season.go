@@ -0,0 +1,172 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// splitOf returns the split of its season that patch v belongs to. Every
+// season starts in split 1, and each of starts in the same season begins the
+// next one.
+func splitOf(v PatchVersion, starts []PatchVersion) int {
+	split := 1
+	for _, s := range starts {
+		if s.Major == v.Major && !s.After(v) && s.Minor > 1 {
+			split++
+		}
+	}
+	return split
+}
+
+// parseSplitStarts parses cfg.SplitStarts, which Validate has checked.
+func parseSplitStarts(cfg Config) []PatchVersion {
+	starts := make([]PatchVersion, 0, len(cfg.SplitStarts))
+	for _, s := range cfg.SplitStarts {
+		if v, err := ParsePatchVersion(s); err == nil {
+			starts = append(starts, v)
+		}
+	}
+	return starts
+}
+
+// AssignSplits sets the split of every patch from the configured split
+// starts, so that changing them also regroups older patches.
+func (db *DB) AssignSplits(ctx context.Context, starts []PatchVersion) error {
+	rows, err := db.QueryContext(ctx, "SELECT version, major, minor, split FROM patches WHERE major IS NOT NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	changed := make(map[string]int)
+	for rows.Next() {
+		var version string
+		var v PatchVersion
+		var split sql.NullInt64
+		if err := rows.Scan(&version, &v.Major, &v.Minor, &split); err != nil {
+			return err
+		}
+		if want := splitOf(v, starts); !split.Valid || int(split.Int64) != want {
+			changed[version] = want
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for version, split := range changed {
+		if _, err := db.ExecContext(ctx, "UPDATE patches SET split = $2 WHERE version = $1", version, split); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetPatches returns every known patch with its metadata, newest first. A
+// season of 0 returns the patches of every season.
+func (db *DB) GetPatches(ctx context.Context, season int) ([]PatchInfo, error) {
+	defer observeQuery("GetPatches", time.Now())
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT version, COALESCE(season, 0), COALESCE(split, 0), released_at, first_seen_at, promoted_at
+		FROM patches
+		WHERE $1 = 0 OR season = $1
+		ORDER BY major DESC NULLS LAST, minor DESC NULLS LAST
+	`, season)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	patches := []PatchInfo{}
+	for rows.Next() {
+		var p PatchInfo
+		var released, firstSeen, promoted sql.NullTime
+		if err := rows.Scan(&p.Version, &p.Season, &p.Split, &released, &firstSeen, &promoted); err != nil {
+			return nil, err
+		}
+		if released.Valid {
+			p.ReleasedAt = &released.Time
+		}
+		if firstSeen.Valid {
+			p.FirstSeenAt = &firstSeen.Time
+		}
+		if promoted.Valid {
+			p.PromotedAt = &promoted.Time
+		}
+		patches = append(patches, p)
+	}
+
+	return patches, rows.Err()
+}
+
+// SetPatchReleaseDate corrects the release date of a patch, which the scraper
+// otherwise takes to be the day it first saw it. It returns sql.ErrNoRows if
+// the patch is unknown.
+func (db *DB) SetPatchReleaseDate(ctx context.Context, version string, releasedAt time.Time) error {
+	res, err := db.ExecContext(ctx, "UPDATE patches SET released_at = $2 WHERE version = $1", version, releasedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetSeasonMatchups returns the matchups of a champion in a role over a whole
+// season: the patches still in matchups and the summaries of those already
+// pruned, with win rates weighted by sample size. ScrapedAt is the latest
+// scrape among the patches still kept.
+func (db *DB) GetSeasonMatchups(ctx context.Context, season int, champName string, role string) ([]Matchup, error) {
+	defer observeQuery("GetSeasonMatchups", time.Now())
+
+	rows, err := db.QueryContext(ctx, `
+		WITH season_rows AS (
+			SELECT m.champion_id, m.opponent_id, m.role, m.win_rate, m.sample_size::BIGINT AS sample_size, m.scraped_at
+			FROM matchups m
+			JOIN patches p ON m.patch = p.version
+			WHERE p.season = $1
+			UNION ALL
+			SELECT s.champion_id, s.opponent_id, s.role, s.win_rate, s.sample_size, NULL
+			FROM season_matchups s
+			WHERE s.season = $1
+		)
+		SELECT c.name, SUM(r.win_rate * r.sample_size) / SUM(r.sample_size), SUM(r.sample_size), MAX(r.scraped_at)
+		FROM season_rows r
+		JOIN champions c ON r.opponent_id = c.id
+		JOIN champions champ ON r.champion_id = champ.id
+		WHERE LOWER(champ.name) = LOWER($2) AND LOWER(r.role) = LOWER($3)
+		GROUP BY c.name
+		HAVING SUM(r.sample_size) > 0
+		ORDER BY 2 DESC
+	`, season, champName, role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matchups []Matchup
+	for rows.Next() {
+		var m Matchup
+		var winRate float64
+		var sampleSize int64
+		var scrapedAt sql.NullTime
+		if err := rows.Scan(&m.Champion, &winRate, &sampleSize, &scrapedAt); err != nil {
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.FormatInt(sampleSize, 10)
+		m.ScrapedAt = scrapedAt.Time
+		matchups = append(matchups, m)
+	}
+
+	return matchups, rows.Err()
+}
@@ -0,0 +1,650 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const maxBatchLookups = 50
+
+// maxPromotions is how much of the promotion history /promotions returns.
+const maxPromotions = 20
+
+// headToHeadThreshold is how many percentage points the two scraped
+// perspectives of a matchup may drift apart before they are flagged.
+const headToHeadThreshold = 2.0
+
+// RunServer serves the REST API on the configured port until ctx is
+// cancelled, then stops accepting connections and waits up to
+// cfg.ShutdownTimeout for in-flight requests to finish.
+func RunServer(ctx context.Context, db *DB, cfg Config) error {
+	slog.Info("Setting up REST API")
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.Port),
+		Handler: NewRouter(db, cfg),
+	}
+	return serveUntilDone(ctx, srv, cfg.ShutdownTimeout)
+}
+
+// serveUntilDone runs srv until it fails or ctx is cancelled, in which case it
+// is shut down gracefully within timeout.
+func serveUntilDone(ctx context.Context, srv *http.Server, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", "addr", srv.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server", "addr", srv.Addr)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("error shutting down server: %v", err)
+	}
+	return nil
+}
+
+func NewRouter(db *DB, cfg Config) *gin.Engine {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.Use(requestLogger())
+	corsConfig := cors.DefaultConfig()
+	corsConfig.AllowOrigins = cfg.AllowedOrigins
+	r.Use(cors.New(corsConfig))
+	r.Use(metricsMiddleware())
+
+	statuses := newStatusCache(db, cfg.StatusCacheTTL)
+	var snapshots *snapshotStore
+	if cfg.Snapshot {
+		snapshots = &snapshotStore{}
+	}
+	reader := newPatchReader(db, snapshots)
+	cached := cacheResponses(newResponseCache(), statuses, cfg.CacheMaxAge)
+
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+
+	r.GET("/healthz", func(c *gin.Context) {
+		c.JSON(200, gin.H{"status": "ok"})
+	})
+
+	r.GET("/readyz", func(c *gin.Context) {
+		readiness := checkReadiness(c.Request.Context(), db, cfg)
+		if !readiness.Ready {
+			loggerFrom(c.Request.Context()).Warn("Readiness check failed", "readiness", readiness)
+			c.JSON(503, readiness)
+			return
+		}
+		c.JSON(200, readiness)
+	})
+
+	r.GET("/matchups/:champion/:role", cached, func(c *gin.Context) {
+		champion := c.Param("champion")
+		role := c.Param("role")
+		limitInt, err := parseLimit(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		sel, aggregate, err := parsePatchSelection(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+		logger.Debug("Received matchups request", "champion", champion, "role", role, "limit", limitInt)
+
+		status, err := requestStatus(c, statuses)
+		if err != nil {
+			logger.Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Debug("Scraping status", "current_patch", status.CurrentPatch,
+			"last_scraped_patch", status.LastScrapedPatch, "is_updating", status.IsUpdating)
+
+		patch := status.LastScrapedPatch
+		if patch == "" {
+			patch = status.CurrentPatch
+			logger.Debug("LastScrapedPatch is empty, using CurrentPatch", "patch", patch)
+		}
+
+		if status.IsUpdating {
+			c.Header("X-Patch-Updating", "true")
+		}
+
+		if aggregate {
+			serveAggregated(c, db, champion, role, limitInt, patch, sel)
+			return
+		}
+
+		matchups, err := reader.TopMatchups(ctx, champion, role, limitInt, status)
+		if err != nil {
+			logger.Error("Error getting top matchups", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(matchups) == 0 {
+			logger.Debug("No matchups found", "champion", champion, "role", role)
+			c.JSON(404, gin.H{"error": "No matchups found", "patch": patch})
+			return
+		}
+
+		logger.Debug("Returning matchups", "count", len(matchups), "champion", champion, "role", role)
+		c.JSON(200, gin.H{"patch": patch, "matchups": matchups})
+	})
+
+	r.GET("/matchups/:champion/:role/all", cached, func(c *gin.Context) {
+		champion := c.Param("champion")
+		role := c.Param("role")
+		sel, aggregate, err := parsePatchSelection(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+		logger.Debug("Received all matchups request", "champion", champion, "role", role)
+
+		status, err := requestStatus(c, statuses)
+		if err != nil {
+			logger.Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Debug("Scraping status", "current_patch", status.CurrentPatch,
+			"last_scraped_patch", status.LastScrapedPatch, "is_updating", status.IsUpdating)
+
+		patch := status.LastScrapedPatch
+		if patch == "" {
+			patch = status.CurrentPatch
+			logger.Debug("LastScrapedPatch is empty, using CurrentPatch", "patch", patch)
+		}
+
+		if status.IsUpdating {
+			c.Header("X-Patch-Updating", "true")
+		}
+
+		if aggregate {
+			serveAggregated(c, db, champion, role, 0, patch, sel)
+			return
+		}
+
+		matchups, err := reader.AllMatchups(ctx, champion, role, status)
+		if err != nil {
+			logger.Error("Error getting all matchups", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if len(matchups) == 0 {
+			logger.Debug("No matchups found", "champion", champion, "role", role)
+			c.JSON(404, gin.H{"error": "No matchups found", "patch": patch})
+			return
+		}
+
+		logger.Debug("Returning matchups", "count", len(matchups), "champion", champion, "role", role)
+		c.JSON(200, gin.H{"patch": patch, "matchups": matchups})
+	})
+
+	r.GET("/matchups/:champion/:role/vs/:opponent", cached, func(c *gin.Context) {
+		champion := c.Param("champion")
+		role := c.Param("role")
+		opponent := c.Param("opponent")
+		// A mirror matchup has only one perspective to compare.
+		if strings.EqualFold(champion, opponent) {
+			c.JSON(400, gin.H{"error": "Champion and opponent must differ"})
+			return
+		}
+
+		threshold := headToHeadThreshold
+		if t := c.Query("threshold"); t != "" {
+			parsed, err := strconv.ParseFloat(t, 64)
+			if err != nil || parsed < 0 {
+				c.JSON(400, gin.H{"error": "Invalid threshold"})
+				return
+			}
+			threshold = parsed
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+		logger.Debug("Received head-to-head request", "champion", champion, "role", role, "opponent", opponent)
+
+		status, err := requestStatus(c, statuses)
+		if err != nil {
+			logger.Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		patch := status.LastScrapedPatch
+		if patch == "" {
+			patch = status.CurrentPatch
+			logger.Debug("LastScrapedPatch is empty, using CurrentPatch", "patch", patch)
+		}
+
+		if status.IsUpdating {
+			c.Header("X-Patch-Updating", "true")
+		}
+
+		h2h, err := db.GetHeadToHead(ctx, champion, opponent, role, patch, threshold)
+		if err != nil {
+			logger.Error("Error getting head-to-head matchup", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		if h2h.ChampionView == nil && h2h.OpponentView == nil {
+			logger.Debug("No matchup found", "champion", champion, "opponent", opponent, "role", role)
+			c.JSON(404, gin.H{"error": "No matchup found", "patch": patch})
+			return
+		}
+
+		if h2h.Disagree {
+			logger.Warn("Matchup perspectives disagree", "champion", champion, "opponent", opponent,
+				"role", role, "discrepancy", h2h.Discrepancy)
+		}
+
+		c.JSON(200, gin.H{"patch": patch, "matchup": h2h})
+	})
+
+	r.POST("/matchups/batch", func(c *gin.Context) {
+		limitInt, err := parseLimit(c)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		var req struct {
+			Lookups []MatchupLookup
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		if len(req.Lookups) == 0 {
+			c.JSON(400, gin.H{"error": "No lookups provided"})
+			return
+		}
+		if len(req.Lookups) > maxBatchLookups {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Too many lookups, maximum is %d", maxBatchLookups)})
+			return
+		}
+		for _, l := range req.Lookups {
+			if l.Champion == "" || l.Role == "" {
+				c.JSON(400, gin.H{"error": "Each lookup requires a champion and a role"})
+				return
+			}
+			if !slices.ContainsFunc(cfg.Roles, func(role string) bool { return strings.EqualFold(role, l.Role) }) {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("Unknown role %s", l.Role)})
+				return
+			}
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+		logger.Debug("Received batch request", "lookups", len(req.Lookups))
+
+		status, err := requestStatus(c, statuses)
+		if err != nil {
+			logger.Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		patch := status.LastScrapedPatch
+		if patch == "" {
+			patch = status.CurrentPatch
+			logger.Debug("LastScrapedPatch is empty, using CurrentPatch", "patch", patch)
+		}
+
+		if status.IsUpdating {
+			c.Header("X-Patch-Updating", "true")
+		}
+
+		results, err := reader.MatchupsBatch(ctx, req.Lookups, limitInt, status)
+		if err != nil {
+			logger.Error("Error getting batch matchups", "error", err)
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		logger.Debug("Returning batch results", "count", len(results))
+		c.JSON(200, gin.H{"patch": patch, "results": results})
+	})
+
+	r.GET("/status", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+
+		status, err := db.GetScrapingStatus(ctx)
+		if err != nil {
+			logger.Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		leader, err := db.GetScraperLeader(ctx)
+		if err != nil {
+			logger.Error("Error getting scraper leader", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, gin.H{"status": status, "leader": leader})
+	})
+
+	r.GET("/promotions", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		promotions, err := db.GetPromotions(ctx, maxPromotions)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting patch promotions", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, gin.H{"promotions": promotions})
+	})
+
+	r.GET("/patches", func(c *gin.Context) {
+		season := 0
+		if v := c.Query("season"); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed <= 0 {
+				c.JSON(400, gin.H{"error": "season must be a positive number"})
+				return
+			}
+			season = parsed
+		}
+
+		ctx := c.Request.Context()
+		patches, err := db.GetPatches(ctx, season)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting patches", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, gin.H{"patches": patches})
+	})
+
+	r.GET("/seasons/:season/matchups/:champion/:role", cached, func(c *gin.Context) {
+		season, err := strconv.Atoi(c.Param("season"))
+		if err != nil || season <= 0 {
+			c.JSON(400, gin.H{"error": "season must be a positive number"})
+			return
+		}
+		champion := c.Param("champion")
+		role := c.Param("role")
+
+		ctx := c.Request.Context()
+		matchups, err := db.GetSeasonMatchups(ctx, season, champion, role)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting season matchups", "season", season, "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		if len(matchups) == 0 {
+			c.JSON(404, gin.H{"error": "No matchups found", "season": season})
+			return
+		}
+
+		c.JSON(200, gin.H{"season": season, "matchups": matchups})
+	})
+
+	r.GET("/export/matchups", func(c *gin.Context) {
+		format := c.DefaultQuery("format", "csv")
+		contentType, ok := exportFormats[format]
+		if !ok {
+			c.JSON(400, gin.H{"error": "format must be csv, jsonl or parquet"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+		filter := ExportFilter{Patch: c.Query("patch"), Role: c.Query("role")}
+		if filter.Patch == "" {
+			status, err := statuses.Get(ctx)
+			if err != nil {
+				logger.Error("Error getting scraping status", "error", err)
+				c.JSON(500, gin.H{"error": "Internal server error"})
+				return
+			}
+			filter.Patch = servedPatchOf(status)
+		}
+		if _, err := ParsePatchVersion(filter.Patch); err != nil {
+			c.JSON(400, gin.H{"error": "patch must be a patch such as 14.10"})
+			return
+		}
+
+		name := "matchups-" + filter.Patch
+		if filter.Role != "" {
+			name += "-" + strings.ToLower(filter.Role)
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+
+		// Rows are written as they are read. Once some have been sent an error
+		// can't change the status any more, so the response is cut short.
+		n, err := ExportMatchups(ctx, db, filter, format, c.Writer)
+		if err != nil {
+			logger.Error("Error exporting matchups", "patch", filter.Patch, "rows", n, "error", err)
+			if !c.Writer.Written() {
+				c.Writer.Header().Del("Content-Type")
+				c.Writer.Header().Del("Content-Disposition")
+				c.JSON(500, gin.H{"error": "Internal server error"})
+			} else {
+				c.Abort()
+			}
+			return
+		}
+		logger.Debug("Exported matchups", "patch", filter.Patch, "role", filter.Role, "format", format, "rows", n)
+	})
+
+	r.GET("/schedule", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		jobs, err := GetSchedule(ctx, db, cfg)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting job schedule", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, gin.H{"jobs": jobs})
+	})
+
+	admin := r.Group("/admin", requireAdmin(cfg.AdminToken))
+
+	admin.POST("/rollback", func(c *gin.Context) {
+		var req struct {
+			Reason string
+		}
+		if err := c.ShouldBindJSON(&req); err != nil || strings.TrimSpace(req.Reason) == "" {
+			c.JSON(400, gin.H{"error": "A reason is required"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		logger := loggerFrom(ctx)
+
+		rollback, err := db.RollbackPatch(ctx, adminUser(c), req.Reason)
+		if errors.Is(err, errNoRollbackTarget) {
+			c.JSON(409, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			logger.Error("Error rolling back patch", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		logger.Warn("Rolled back served patch", "patch", rollback.Patch, "from", rollback.PreviousPatch,
+			"by", rollback.PerformedBy, "reason", rollback.Reason)
+		c.JSON(200, gin.H{"rollback": rollback})
+	})
+
+	admin.PUT("/patches/:version", func(c *gin.Context) {
+		var req struct {
+			ReleasedAt string
+		}
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request body"})
+			return
+		}
+		releasedAt, err := time.Parse(time.DateOnly, req.ReleasedAt)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "ReleasedAt must be a date such as 2024-05-15"})
+			return
+		}
+
+		ctx := c.Request.Context()
+		version := c.Param("version")
+		err = db.SetPatchReleaseDate(ctx, version, releasedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Unknown patch", "patch": version})
+			return
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("Error setting patch release date", "patch", version, "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		loggerFrom(ctx).Info("Set patch release date", "patch", version, "released_at", req.ReleasedAt, "by", adminUser(c))
+		c.JSON(200, gin.H{"patch": version, "ReleasedAt": req.ReleasedAt})
+	})
+
+	admin.GET("/retention", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		report, err := ApplyRetention(ctx, db, cfg.RetainPatches, true)
+		if err != nil {
+			loggerFrom(ctx).Error("Error planning retention", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.JSON(200, report)
+	})
+
+	admin.POST("/import", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		format := c.DefaultQuery("format", "csv")
+		body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+		report, err := ImportMatchups(ctx, db, format, body)
+		if errors.Is(err, errInvalidImport) {
+			c.JSON(400, gin.H{"error": err.Error(), "import": report})
+			return
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("Error importing matchups", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		loggerFrom(ctx).Info("Imported matchups", "matchups", report.Matchups, "patches", report.Patches, "by", adminUser(c))
+		c.JSON(200, report)
+	})
+
+	r.GET("/champions", cached, func(c *gin.Context) {
+		ctx := c.Request.Context()
+		status, err := requestStatus(c, statuses)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting scraping status", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		champions, err := reader.AllChampions(ctx, status)
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting all champions", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		if len(champions) == 0 {
+			c.JSON(404, gin.H{"error": "No champions found"})
+			return
+		}
+
+		c.JSON(200, gin.H{"champions": withAvatarURLs(champions, cfg.PublicURL)})
+	})
+
+	avatars := newAvatarCache(cfg.AvatarDir)
+	r.GET("/avatars/:champion", func(c *gin.Context) {
+		ctx := c.Request.Context()
+		size, err := parseAvatarSize(c.Query("size"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		champ, err := db.GetChampion(ctx, c.Param("champion"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(404, gin.H{"error": "Unknown champion"})
+			return
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("Error getting champion", "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		f, err := avatars.Open(ctx, champ, size)
+		if errors.Is(err, errAvatarUnavailable) {
+			c.JSON(502, gin.H{"error": "Avatar unavailable"})
+			return
+		}
+		if err != nil {
+			loggerFrom(ctx).Error("Error serving avatar", "champion", champ.Name, "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			loggerFrom(ctx).Error("Error serving avatar", "champion", champ.Name, "error", err)
+			c.JSON(500, gin.H{"error": "Internal server error"})
+			return
+		}
+
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(avatarMaxAge.Seconds())))
+		http.ServeContent(c.Writer, c.Request, info.Name(), info.ModTime(), f)
+	})
+
+	return r
+}
+
+// parseLimit reads ?limit=, the number of matchups to return, which is 8 when
+// absent. A limit of 0 returns every matchup.
+func parseLimit(c *gin.Context) (int, error) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "8"))
+	if err != nil || limit < 0 {
+		return 0, fmt.Errorf("limit must be a number of at least 0")
+	}
+	return limit, nil
+}
@@ -0,0 +1,183 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is every champion and matchup of one patch, loaded into memory so
+// that the promoted patch can be served without touching the database. Served
+// data only changes when a patch is promoted or refreshed, either of which
+// changes its servedVersion.
+type Snapshot struct {
+	Patch     string
+	Version   string
+	BuiltAt   time.Time
+	Champions []Champion
+	// Matchups is keyed by matchupKey and ordered by win rate, highest first.
+	Matchups map[string][]Matchup
+}
+
+// BuildSnapshot loads every champion and every matchup of patch.
+func (db *DB) BuildSnapshot(ctx context.Context, patch string) (*Snapshot, error) {
+	defer observeQuery("BuildSnapshot", time.Now())
+
+	champions, err := db.GetAllChampions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT champ.name, m.role, c.name, m.win_rate, m.sample_size, m.scraped_at
+		FROM matchups m
+		JOIN champions c ON m.opponent_id = c.id
+		JOIN champions champ ON m.champion_id = champ.id
+		WHERE m.patch = $1
+		ORDER BY m.win_rate DESC
+	`, patch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snap := &Snapshot{Patch: patch, BuiltAt: time.Now(), Champions: champions, Matchups: make(map[string][]Matchup)}
+	for rows.Next() {
+		var champName, role string
+		var m Matchup
+		var winRate float64
+		var sampleSize int
+		if err := rows.Scan(&champName, &role, &m.Champion, &winRate, &sampleSize, &m.ScrapedAt); err != nil {
+			return nil, err
+		}
+		m.WinRate = fmt.Sprintf("%.2f", winRate)
+		m.SampleSize = strconv.Itoa(sampleSize)
+		key := matchupKey(champName, role)
+		snap.Matchups[key] = append(snap.Matchups[key], m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return snap, nil
+}
+
+// snapshotStore holds the API's snapshot of the promoted patch, built on
+// first use of each served version.
+type snapshotStore struct {
+	current  atomic.Pointer[Snapshot]
+	building sync.Mutex
+}
+
+// get returns the snapshot for version, or nil if none is loaded yet.
+func (s *snapshotStore) get(version string) *Snapshot {
+	snap := s.current.Load()
+	if snap == nil || snap.Version != version {
+		return nil
+	}
+	return snap
+}
+
+// refresh builds the snapshot for version of patch and makes it current.
+func (s *snapshotStore) refresh(ctx context.Context, db *DB, patch string, version string) error {
+	s.building.Lock()
+	defer s.building.Unlock()
+
+	if s.get(version) != nil {
+		return nil
+	}
+
+	snap, err := db.BuildSnapshot(ctx, patch)
+	if err != nil {
+		return err
+	}
+	snap.Version = version
+	s.current.Store(snap)
+
+	count := 0
+	for _, matchups := range snap.Matchups {
+		count += len(matchups)
+	}
+	loggerFrom(ctx).Info("Built matchup snapshot", "patch", patch, "champions", len(snap.Champions), "matchups", count)
+	return nil
+}
+
+// ensure starts building the snapshot for version of patch in the background
+// unless it is already loaded or being built. Requests keep falling back to
+// the database until it is ready.
+func (s *snapshotStore) ensure(ctx context.Context, db *DB, patch string, version string) {
+	if patch == "" || s.get(version) != nil || !s.building.TryLock() {
+		return
+	}
+	s.building.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := s.refresh(ctx, db, patch, version); err != nil {
+			loggerFrom(ctx).Error("Error building matchup snapshot", "patch", patch, "error", err)
+		}
+	}()
+}
+
+// patchReader serves the patch of a ScrapingStatus from its snapshot when one
+// is loaded and from the database otherwise. With a nil store it always reads
+// the database.
+type patchReader struct {
+	db        *DB
+	snapshots *snapshotStore
+}
+
+func newPatchReader(db *DB, snapshots *snapshotStore) *patchReader {
+	return &patchReader{db: db, snapshots: snapshots}
+}
+
+func (r *patchReader) snapshot(ctx context.Context, status ScrapingStatus) *Snapshot {
+	if r.snapshots == nil {
+		return nil
+	}
+	version := servedVersion(status)
+	r.snapshots.ensure(ctx, r.db, servedPatchOf(status), version)
+	snap := r.snapshots.get(version)
+	if snap != nil {
+		snapshotReads.WithLabelValues("snapshot").Inc()
+	} else {
+		snapshotReads.WithLabelValues("database").Inc()
+	}
+	return snap
+}
+
+func (r *patchReader) TopMatchups(ctx context.Context, champName string, role string, limit int, status ScrapingStatus) ([]Matchup, error) {
+	if snap := r.snapshot(ctx, status); snap != nil {
+		matchups := snap.Matchups[matchupKey(champName, role)]
+		if limit > 0 && len(matchups) > limit {
+			matchups = matchups[:limit]
+		}
+		return matchups, nil
+	}
+	return r.db.GetTopMatchups(ctx, champName, role, limit, servedPatchOf(status))
+}
+
+func (r *patchReader) AllMatchups(ctx context.Context, champName string, role string, status ScrapingStatus) ([]Matchup, error) {
+	if snap := r.snapshot(ctx, status); snap != nil {
+		return snap.Matchups[matchupKey(champName, role)], nil
+	}
+	return r.db.GetAllMatchups(ctx, champName, role, servedPatchOf(status))
+}
+
+func (r *patchReader) MatchupsBatch(ctx context.Context, lookups []MatchupLookup, limit int, status ScrapingStatus) ([]MatchupLookupResult, error) {
+	if snap := r.snapshot(ctx, status); snap != nil {
+		return resolveLookups(snap.Matchups, lookups, limit), nil
+	}
+	return r.db.GetMatchupsBatch(ctx, lookups, limit, servedPatchOf(status))
+}
+
+func (r *patchReader) AllChampions(ctx context.Context, status ScrapingStatus) ([]Champion, error) {
+	if snap := r.snapshot(ctx, status); snap != nil {
+		return snap.Champions, nil
+	}
+	return r.db.GetAllChampions(ctx)
+}
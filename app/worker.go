@@ -0,0 +1,402 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// scraperLockKey identifies the Postgres advisory lock used to elect a single
+// scraper leader across every process connected to the same database.
+const scraperLockKey int64 = 0x7069636b
+
+// errLeaseLost is the cancellation cause when the scraper lease is lost
+// mid-run.
+var errLeaseLost = errors.New("scraper lease lost")
+
+// StartScraping runs the scraper's scheduled jobs until ctx is cancelled,
+// while this process holds the scraper lease. On cancellation the champion
+// currently being scraped is finished and checkpointed before it returns, so
+// a restarted scraper resumes where this one stopped.
+func StartScraping(ctx context.Context, db *DB, cfg Config) {
+	id := instanceID()
+	logger := slog.Default().With("instance_id", id)
+	logger.Info("Scraping process started")
+	for ctx.Err() == nil {
+		lease, err := db.AcquireLease(scraperLockKey, id, cfg.LeaseRenewal)
+		if err != nil {
+			logger.Error("Error acquiring scraper lease", "error", err)
+		}
+		if lease == nil {
+			sleepContext(ctx, cfg.LeaseRenewal)
+			continue
+		}
+		logger.Info("Became scraper leader")
+
+		// The jobs' context is cancelled either by shutdown or by losing the
+		// lease, whichever comes first.
+		leaseCtx, cancel := context.WithCancelCause(ctx)
+		go func() {
+			select {
+			case <-lease.Lost():
+				cancel(errLeaseLost)
+			case <-leaseCtx.Done():
+			}
+		}()
+
+		s, err := newScraperScheduler(db, cfg)
+		if err == nil {
+			err = s.Run(withLogger(leaseCtx, logger))
+		}
+		if err != nil {
+			logger.Error("Error running scheduled jobs", "error", err)
+			sleepContext(leaseCtx, cfg.LeaseRenewal)
+		}
+
+		if errors.Is(context.Cause(leaseCtx), errLeaseLost) {
+			logger.Info("Lost scraper leadership")
+		}
+		cancel(nil)
+		if err := lease.Release(); err != nil {
+			logger.Error("Error releasing scraper lease", "error", err)
+		}
+	}
+	logger.Info("Scraping process stopped")
+}
+
+// RunScraper runs StartScraping and, once ctx is cancelled, waits at most
+// cfg.ShutdownTimeout for the in-flight champion to finish.
+func RunScraper(ctx context.Context, db *DB, cfg Config) {
+	done := make(chan struct{})
+	go func() {
+		StartScraping(ctx, db, cfg)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	select {
+	case <-done:
+	case <-time.After(cfg.ShutdownTimeout):
+		slog.Warn("Scraper did not stop within the shutdown timeout", "timeout", cfg.ShutdownTimeout.String())
+	}
+}
+
+// sleepContext sleeps for d or until ctx is cancelled. It reports whether the
+// full duration elapsed.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// newScraperScheduler registers the scraper's jobs:
+//
+//   - patch-check asks op.gg for its current patch and, when it is new or an
+//     update was interrupted, marks the status as updating and triggers
+//     full-scrape straight away.
+//   - full-scrape scrapes the patch being updated into staging and promotes
+//     it once it is valid and has enough samples.
+//   - refresh rescrapes the served patch's champions in place, unless op.gg
+//     has moved on to a newer patch.
+//   - cleanup drops staging rows and checkpoints left over from old patches.
+//   - retention folds patches older than cfg.RetainPatches into season
+//     summaries and deletes them.
+//
+// Every page the scrapes download is archived when cfg.ArchiveURL is set.
+func newScraperScheduler(db *DB, cfg Config) (*scheduler, error) {
+	archive, err := NewPageArchive(db, cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := newScheduler(db, cfg)
+	var refreshSkipped string
+	jobs := map[string]JobFunc{
+		"patch-check": func(ctx context.Context, _ time.Time) error {
+			updating, err := checkPatch(ctx, db, cfg)
+			if updating {
+				s.Trigger("full-scrape")
+			}
+			return err
+		},
+		"full-scrape": func(ctx context.Context, _ time.Time) error {
+			return scrapeNewPatch(ctx, db, cfg, archive)
+		},
+		"refresh": func(ctx context.Context, lastStarted time.Time) error {
+			return refreshJob(ctx, db, cfg, archive, lastStarted, &refreshSkipped)
+		},
+		"cleanup": func(ctx context.Context, _ time.Time) error {
+			return cleanupJob(ctx, db)
+		},
+		"retention": func(ctx context.Context, _ time.Time) error {
+			report, err := ApplyRetention(ctx, db, cfg.RetainPatches, false)
+			if err != nil {
+				return err
+			}
+			loggerFrom(ctx).Info("Applied retention policy", "kept", len(report.Keep), "pruned", len(report.Prune))
+			return nil
+		},
+	}
+	for _, name := range jobNames {
+		if err := s.Add(name, jobs[name]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// checkPatch records op.gg's current patch. It reports whether a patch update
+// is in progress: a newer patch, the first run, or an update that a previous
+// leader didn't finish. A patch older than the current one is ignored.
+func checkPatch(ctx context.Context, db *DB, cfg Config) (bool, error) {
+	logger := loggerFrom(ctx)
+	currentPatch, err := ScrapePatchInfo(ctx, cfg.OpGGBaseURL)
+	if err != nil {
+		return false, fmt.Errorf("error scraping patch info: %v", err)
+	}
+	logger.Info("Scraped current patch", "patch", currentPatch.Version)
+
+	if err := db.SavePatch(ctx, currentPatch); err != nil {
+		return false, fmt.Errorf("error saving patch: %v", err)
+	}
+	if err := db.AssignSplits(ctx, parseSplitStarts(cfg)); err != nil {
+		return false, fmt.Errorf("error assigning patch splits: %v", err)
+	}
+
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return false, fmt.Errorf("error getting scraping status: %v", err)
+	}
+	logger.Info("Current scraping status", "current_patch", status.CurrentPatch,
+		"last_scraped_patch", status.LastScrapedPatch, "is_updating", status.IsUpdating)
+
+	if status.CurrentPatch != "" {
+		scraped, err := ParsePatchVersion(currentPatch.Version)
+		if err != nil {
+			return false, err
+		}
+		// A stored patch from before versions were validated can't be
+		// compared, so any successfully parsed one replaces it.
+		current, err := ParsePatchVersion(status.CurrentPatch)
+		if err == nil {
+			switch scraped.Compare(current) {
+			case 0:
+				if status.IsUpdating {
+					return true, nil
+				}
+				if status.LastScrapedPatch != "" {
+					logger.Info("No new patch detected")
+					return false, nil
+				}
+			case -1:
+				// op.gg's CDN sometimes serves a stale page for a while after
+				// a patch; going back to the older patch would rescrape it.
+				logger.Warn("op.gg reports an older patch than the current one, ignoring it",
+					"scraped_patch", currentPatch.Version, "current_patch", status.CurrentPatch)
+				return status.IsUpdating, nil
+			}
+		}
+	}
+
+	logger.Info("New patch detected or first run", "patch", currentPatch.Version)
+	status.CurrentPatch = currentPatch.Version
+	status.IsUpdating = true
+	if err := db.UpdateScrapingStatus(ctx, status); err != nil {
+		return false, fmt.Errorf("error updating scraping status: %v", err)
+	}
+	return true, nil
+}
+
+// scrapeNewPatch scrapes the patch being updated into staging, resuming from
+// the checkpoint, and promotes it once it passes validation and has enough
+// samples. It does nothing when no update is in progress. When ctx is
+// cancelled it stops after the current champion, leaving IsUpdating set so
+// that the next run resumes the update.
+func scrapeNewPatch(ctx context.Context, db *DB, cfg Config, archive *PageArchive) error {
+	logger := loggerFrom(ctx)
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting scraping status: %v", err)
+	}
+	if !status.IsUpdating {
+		logger.Info("No patch update in progress, skipping full scrape")
+		return nil
+	}
+	patch := status.CurrentPatch
+
+	logger.Info("Starting to scrape champions", "patch", patch)
+	champions, err := ScrapeChampions(ctx, cfg.OpGGBaseURL, archive.Recorder(ctx, patch))
+	if err != nil {
+		return fmt.Errorf("error scraping champions: %v", err)
+	}
+	logger.Info("Scraped champions", "count", len(champions))
+
+	done, err := db.GetScrapedChampions(ctx, patch)
+	if err != nil {
+		return fmt.Errorf("error reading scrape checkpoint: %v", err)
+	}
+	if len(done) > 0 {
+		logger.Info("Resuming from checkpoint", "already_scraped", len(done))
+	}
+
+	// Work on a champion is not cancelled by shutdown; cancellation is only
+	// checked between champions.
+	workCtx := context.WithoutCancel(ctx)
+	record := archive.Recorder(workCtx, patch)
+
+	cycleStart := time.Now()
+	saved := 0
+	for _, champ := range champions {
+		if ctx.Err() != nil {
+			logger.Warn("Scraping interrupted, stopping after checkpoint", "cause", context.Cause(ctx))
+			return fmt.Errorf("interrupted: %w", context.Cause(ctx))
+		}
+		if done[champ.Name] {
+			continue
+		}
+		if err := db.SaveChampion(workCtx, champ); err != nil {
+			logger.Error("Error saving champion", "champion", champ.Name, "error", err)
+			continue
+		}
+		logger.Debug("Scraping matchups", "champion", champ.Name)
+		matchups, err := ScrapeMatchups(workCtx, cfg.OpGGBaseURL, champ.Name, cfg.Roles, record)
+		if err != nil {
+			logger.Error("Error scraping matchups", "champion", champ.Name, "error", err)
+			championScrapes.WithLabelValues("failure").Inc()
+			continue
+		}
+		champSaved := 0
+		for role, roleMatchups := range matchups {
+			logger.Debug("Staging matchups", "count", len(roleMatchups), "champion", champ.Name, "role", role)
+			if err := db.StageMatchups(workCtx, champ.Name, role, roleMatchups, patch); err != nil {
+				logger.Error("Error staging matchups", "champion", champ.Name, "role", role, "error", err)
+				continue
+			}
+			champSaved += len(roleMatchups)
+		}
+		matchupsSaved.Add(float64(champSaved))
+		saved += champSaved
+		// A champion page that parses but yields nothing usually means the
+		// op.gg markup changed, so it counts as a failure.
+		if champSaved == 0 {
+			championScrapes.WithLabelValues("failure").Inc()
+		} else {
+			championScrapes.WithLabelValues("success").Inc()
+		}
+		if err := db.MarkChampionScraped(workCtx, patch, champ.Name); err != nil {
+			logger.Error("Error saving scrape checkpoint", "champion", champ.Name, "error", err)
+		}
+		logger.Info("Finished scraping matchups", "champion", champ.Name, "saved", champSaved)
+		sleepContext(ctx, cfg.ScrapingDelay)
+	}
+
+	scrapeCycleDuration.Observe(time.Since(cycleStart).Seconds())
+	lastScrapeMatchups.Set(float64(saved))
+	logger.Info("Saved matchups for patch", "count", saved, "patch", patch)
+
+	report, err := db.ValidateStaged(ctx, patch)
+	if err != nil {
+		return fmt.Errorf("error validating staged matchups: %v", err)
+	}
+	if len(report.Problems) > 0 {
+		// The old patch keeps being served. IsUpdating stays set, so the
+		// next run scrapes the new one again from scratch.
+		if err := db.DiscardStaged(ctx, patch); err != nil {
+			logger.Error("Error discarding staged matchups", "patch", patch, "error", err)
+		}
+		return fmt.Errorf("staged matchups for patch %s failed validation and were discarded: %s",
+			patch, strings.Join(report.Problems, "; "))
+	}
+
+	stagedTotalSamples.Set(float64(report.TotalSamples))
+	stagedMedianSamples.Set(report.MedianSamples)
+
+	// With nothing served yet any data is better than none; otherwise the
+	// old patch stays up until the new one has enough games behind it.
+	if shortfall := sampleShortfall(report, cfg); status.LastScrapedPatch != "" && len(shortfall) > 0 {
+		logger.Info("Not enough samples to promote yet, will scrape the patch again on the next run",
+			"patch", patch, "shortfall", shortfall)
+		// Samples only grow if every champion is scraped again, which the
+		// checkpoint would otherwise skip.
+		if err := db.ClearScrapeProgress(ctx, patch); err != nil {
+			return fmt.Errorf("error clearing scrape checkpoint: %v", err)
+		}
+		return nil
+	}
+
+	reason := fmt.Sprintf("%d matchups for %d champions staged, %d samples in total, median %.0f",
+		report.Matchups, report.Champions, report.TotalSamples, report.MedianSamples)
+	promotion, err := db.PromotePatch(ctx, patch, "scraper "+instanceID(), reason)
+	if err != nil {
+		return fmt.Errorf("error promoting patch %s: %v", patch, err)
+	}
+	logger.Info("Promoted patch", "patch", promotion.Patch, "previous_patch", promotion.PreviousPatch, "reason", reason)
+	return nil
+}
+
+// refreshJob refreshes the served patch's champions that haven't been scraped
+// since the previous refresh started, or all of them on the first run.
+//
+// op.gg only serves its current patch's numbers, so the served patch can't be
+// refreshed while a newer one is being staged, nor after a rollback: either
+// way op.gg's pages would overwrite it with the newer patch's numbers. The
+// served patch is then left as it was until the newer one is promoted. The
+// skip is logged at Info once per pair of served and current patch, which
+// skipped remembers between runs, and at Debug after that.
+func refreshJob(ctx context.Context, db *DB, cfg Config, archive *PageArchive, lastStarted time.Time, skipped *string) error {
+	logger := loggerFrom(ctx)
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting scraping status: %v", err)
+	}
+	if status.IsUpdating || status.LastScrapedPatch == "" || status.LastScrapedPatch != status.CurrentPatch {
+		level := slog.LevelInfo
+		if key := status.LastScrapedPatch + " " + status.CurrentPatch; *skipped == key {
+			level = slog.LevelDebug
+		} else {
+			*skipped = key
+		}
+		logger.Log(ctx, level, "Served patch is not op.gg's current patch, skipping refresh",
+			"served_patch", status.LastScrapedPatch, "current_patch", status.CurrentPatch, "is_updating", status.IsUpdating)
+		return nil
+	}
+	*skipped = ""
+
+	cutoff := lastStarted
+	if cutoff.IsZero() {
+		cutoff = time.Now()
+	}
+	return refreshServedPatch(ctx, db, cfg, archive, status.LastScrapedPatch, cutoff)
+}
+
+// cleanupJob drops the staging rows and scrape checkpoints of every patch but
+// the current one. They are left behind when op.gg moves on to a new patch
+// before the previous one was promoted.
+func cleanupJob(ctx context.Context, db *DB) error {
+	status, err := db.GetScrapingStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting scraping status: %v", err)
+	}
+	if status.CurrentPatch == "" {
+		return nil
+	}
+	staged, progress, err := db.PruneStaging(ctx, status.CurrentPatch)
+	if err != nil {
+		return fmt.Errorf("error pruning staging: %v", err)
+	}
+	loggerFrom(ctx).Info("Pruned leftover staging data", "keep_patch", status.CurrentPatch,
+		"staged_rows", staged, "checkpoint_rows", progress)
+	return nil
+}
@@ -0,0 +1,40 @@
+// Command api serves the REST API without running the scraper.
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"pickhelper/go/app"
+)
+
+func main() {
+	log.Println("API starting...")
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if err := app.SetupLogging(cfg); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+
+	db, err := app.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := app.RunServer(ctx, db, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	log.Println("Shutdown complete")
+}
@@ -0,0 +1,39 @@
+// Command scraper runs the op.gg scraping loop without serving the API. Any
+// number of scraper processes may be started; a Postgres advisory lock makes
+// sure only one of them scrapes at a time.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"pickhelper/go/app"
+)
+
+func main() {
+	log.Println("Scraper starting...")
+
+	cfg, err := app.LoadConfig()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if err := app.SetupLogging(cfg); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+
+	db, err := app.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go app.ServeMetrics(ctx, cfg)
+	app.RunScraper(ctx, db, cfg)
+	log.Println("Shutdown complete")
+}
@@ -1,262 +1,265 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
-	"time"
+	"strings"
+	"sync"
+	"syscall"
 
-	"github.com/gin-contrib/cors"
-	"github.com/gin-gonic/gin"
+	"pickhelper/go/app"
 )
 
-const patchUpdateDelay = 48 * time.Hour
-const scrapingDelay = 30 * time.Second
-const scrapingInterval = 6 * time.Hour
+const usage = "usage: pickhelper [all|api|scraper|migrate [up|down [n]|status]|prune [--dry-run]|export [flags]|import [-format csv|jsonl] FILE|reparse [flags]]"
 
 func main() {
-	log.Println("Application starting...")
-
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL environment variable is not set")
+	mode := "all"
+	if len(os.Args) > 1 {
+		mode = os.Args[1]
 	}
-	log.Printf("Connecting to database: %s", dbURL)
 
-	db, err := NewDB(dbURL)
+	log.Printf("Application starting in %s mode...", mode)
+
+	cfg, err := app.LoadConfig()
 	if err != nil {
-		log.Fatalf("Error connecting to the database: %v", err)
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	if err := app.SetupLogging(cfg); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
 	}
-	defer db.Close()
 
-	if err := db.CreateTables(); err != nil {
-		log.Fatalf("Error creating tables: %v", err)
+	if mode == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
 	}
-	log.Println("Database tables created/verified")
 
-	// Start scraping in a separate goroutine
-	log.Println("Starting scraping process in background...")
-	go startScraping(db)
+	db, err := app.Open(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
 
-	// Set up REST API
-	log.Println("Setting up REST API...")
-	r := gin.Default()
-	config := cors.DefaultConfig()
-	config.AllowOrigins = []string{"http://localhost:3000"}
-	r.Use(cors.New(config))
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	switch mode {
+	case "all":
+		cfg.RunsScraper = true
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			app.RunScraper(ctx, db, cfg)
+		}()
+
+		if err := app.RunServer(ctx, db, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+		wg.Wait()
+	case "api":
+		if err := app.RunServer(ctx, db, cfg); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	case "scraper":
+		go app.ServeMetrics(ctx, cfg)
+		app.RunScraper(ctx, db, cfg)
+	case "prune":
+		runPrune(ctx, db, cfg, os.Args[2:])
+	case "export":
+		runExport(ctx, db, os.Args[2:])
+	case "import":
+		runImport(ctx, db, os.Args[2:])
+	case "reparse":
+		runReparse(ctx, db, cfg, os.Args[2:])
+	default:
+		log.Fatal(usage)
+	}
+	log.Println("Shutdown complete")
+}
 
-	r.GET("/matchups/:champion/:role", func(c *gin.Context) {
-		champion := c.Param("champion")
-		role := c.Param("role")
-		limit := c.DefaultQuery("limit", "8")
-		limitInt, _ := strconv.Atoi(limit)
+func runMigrate(cfg app.Config, args []string) {
+	db, err := app.NewDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Error connecting to the database: %v", err)
+	}
+	defer db.Close()
 
-		log.Printf("Received request for /matchups/%s/%s with limit %d", champion, role, limitInt)
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
 
-		status, err := db.GetScrapingStatus()
-		if err != nil {
-			log.Printf("Error getting scraping status: %v", err)
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+	switch action {
+	case "up":
+		if err := db.Migrate(); err != nil {
+			log.Fatalf("Error applying migrations: %v", err)
 		}
-
-		log.Printf("Scraping status: CurrentPatch=%s, LastScrapedPatch=%s, IsUpdating=%v",
-			status.CurrentPatch, status.LastScrapedPatch, status.IsUpdating)
-
-		patch := status.LastScrapedPatch
-		if patch == "" {
-			patch = status.CurrentPatch
-			log.Printf("LastScrapedPatch is empty, using CurrentPatch: %s", patch)
+		log.Println("Migrations applied")
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(usage)
+			}
 		}
-
-		if status.IsUpdating {
-			c.Header("X-Patch-Updating", "true")
+		if err := db.MigrateDown(steps); err != nil {
+			log.Fatalf("Error reverting migrations: %v", err)
 		}
-
-		log.Printf("Calling GetTopMatchups with champion=%s, role=%s, limit=%d, patch=%s",
-			champion, role, limitInt, patch)
-
-		matchups, err := db.GetTopMatchups(champion, role, limitInt, patch)
+		log.Printf("Reverted up to %d migrations", steps)
+	case "status":
+		statuses, err := db.MigrationStatus()
 		if err != nil {
-			log.Printf("Error getting top matchups: %v", err)
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+			log.Fatalf("Error reading migration status: %v", err)
 		}
-
-		log.Printf("GetTopMatchups returned %d matchups", len(matchups))
-
-		if len(matchups) == 0 {
-			log.Printf("No matchups found for %s in %s role", champion, role)
-			c.JSON(404, gin.H{"error": "No matchups found", "patch": patch})
-			return
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			log.Printf("%04d_%s: %s", s.Version, s.Name, state)
 		}
+	default:
+		log.Fatal(usage)
+	}
+}
 
-		log.Printf("Returning %d matchups for %s in %s role", len(matchups), champion, role)
-		c.JSON(200, gin.H{"patch": patch, "matchups": matchups})
-	})
-
-	r.GET("/matchups/:champion/:role/all", func(c *gin.Context) {
-		champion := c.Param("champion")
-		role := c.Param("role")
-
-		log.Printf("Received request for /matchups/%s/%s/all", champion, role)
-
-		status, err := db.GetScrapingStatus()
-		if err != nil {
-			log.Printf("Error getting scraping status: %v", err)
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+func runPrune(ctx context.Context, db *app.DB, cfg app.Config, args []string) {
+	dryRun := false
+	for _, arg := range args {
+		if arg != "--dry-run" {
+			log.Fatal(usage)
 		}
+		dryRun = true
+	}
 
-		log.Printf("Scraping status: CurrentPatch=%s, LastScrapedPatch=%s, IsUpdating=%v",
-			status.CurrentPatch, status.LastScrapedPatch, status.IsUpdating)
-
-		patch := status.LastScrapedPatch
-		if patch == "" {
-			patch = status.CurrentPatch
-			log.Printf("LastScrapedPatch is empty, using CurrentPatch: %s", patch)
-		}
+	report, err := app.ApplyRetention(ctx, db, cfg.RetainPatches, dryRun)
+	if err != nil {
+		log.Fatalf("Error applying retention: %v", err)
+	}
 
-		if status.IsUpdating {
-			c.Header("X-Patch-Updating", "true")
-		}
+	verb := "Pruned"
+	if dryRun {
+		verb = "Would prune"
+	}
+	log.Printf("Keeping %d patches: %s", len(report.Keep), strings.Join(report.Keep, ", "))
+	for _, p := range report.Prune {
+		log.Printf("%s patch %s into season %d: %d matchups, %d staged matchups, %d checkpoints, delete patch row: %t",
+			verb, p.Patch, p.Season, p.Matchups, p.StagedMatchups, p.ScrapeProgress, p.DeletePatch)
+	}
+}
 
-		log.Printf("Calling GetAllMatchups with champion=%s, role=%s, patch=%s",
-			champion, role, patch)
+func runExport(ctx context.Context, db *app.DB, args []string) {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	patch := flags.String("patch", "", "patch to export (default: the served patch)")
+	role := flags.String("role", "", "role to export (default: every role)")
+	format := flags.String("format", "csv", "csv, jsonl or parquet")
+	out := flags.String("out", "", "file to write (required)")
+	flags.Parse(args)
+	if *out == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
 
-		matchups, err := db.GetAllMatchups(champion, role, patch)
+	filter := app.ExportFilter{Patch: *patch, Role: *role}
+	if filter.Patch == "" {
+		status, err := db.GetScrapingStatus(ctx)
 		if err != nil {
-			log.Printf("Error getting all matchups: %v", err)
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
+			log.Fatalf("Error getting scraping status: %v", err)
 		}
-
-		log.Printf("GetAllMatchups returned %d matchups", len(matchups))
-
-		if len(matchups) == 0 {
-			log.Printf("No matchups found for %s in %s role", champion, role)
-			c.JSON(404, gin.H{"error": "No matchups found", "patch": patch})
-			return
+		filter.Patch = status.LastScrapedPatch
+		if filter.Patch == "" {
+			log.Fatal("No patch is being served yet, pass -patch")
 		}
+	}
 
-		log.Printf("Returning %d matchups for %s in %s role", len(matchups), champion, role)
-		c.JSON(200, gin.H{"patch": patch, "matchups": matchups})
-	})
-
-	r.GET("/champions", func(c *gin.Context) {
-		champions, err := db.GetAllChampions()
-		if err != nil {
-			log.Printf("Error getting all champions: %v", err)
-			c.JSON(500, gin.H{"error": "Internal server error"})
-			return
-		}
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("Error creating export file: %v", err)
+	}
+	n, err := app.ExportMatchups(ctx, db, filter, *format, f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(*out)
+		log.Fatalf("Error exporting matchups: %v", err)
+	}
+	log.Printf("Exported %d matchups for patch %s to %s", n, filter.Patch, *out)
+}
 
-		if len(champions) == 0 {
-			c.JSON(404, gin.H{"error": "No champions found"})
-			return
-		}
+func runImport(ctx context.Context, db *app.DB, args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	format := flags.String("format", "", "csv or jsonl (default: from the file extension)")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal(usage)
+	}
+	in := flags.Arg(0)
+	if *format == "" {
+		*format = strings.TrimPrefix(filepath.Ext(in), ".")
+	}
 
-		c.JSON(200, gin.H{"champions": champions})
-	})
+	f, err := os.Open(in)
+	if err != nil {
+		log.Fatalf("Error opening import file: %v", err)
+	}
+	defer f.Close()
 
-	log.Println("Starting server on :8080")
-	if err := r.Run(":8080"); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+	report, err := app.ImportMatchups(ctx, db, *format, f)
+	if err != nil {
+		log.Fatalf("Error importing matchups after %d matchups: %v", report.Matchups, err)
+	}
+	log.Printf("Imported %d matchups of %d champions on patches %s from %s",
+		report.Matchups, report.Champions, strings.Join(report.Patches, ", "), in)
+	if report.Served != "" {
+		log.Printf("Serving imported patch %s", report.Served)
 	}
 }
 
-func startScraping(db *DB) {
-	log.Println("Scraping process started")
-	for {
-		log.Println("Starting a scraping cycle")
-		currentPatch, err := ScrapePatchInfo()
-		if err != nil {
-			log.Printf("Error scraping patch info: %v", err)
-			time.Sleep(1 * time.Hour)
-			continue
-		}
-		log.Printf("Current patch: %s", currentPatch.Version)
+func runReparse(ctx context.Context, db *app.DB, cfg app.Config, args []string) {
+	flags := flag.NewFlagSet("reparse", flag.ExitOnError)
+	patch := flags.String("patch", "", "patch to reparse (default: the served patch)")
+	champion := flags.String("champion", "", "only reparse this champion's pages")
+	role := flags.String("role", "", "only reparse pages of this role")
+	dryRun := flags.Bool("dry-run", false, "parse the pages without saving anything")
+	flags.Parse(args)
 
-		// Save the new patch first
-		if err := db.SavePatch(currentPatch); err != nil {
-			log.Printf("Error saving new patch: %v", err)
-			time.Sleep(1 * time.Hour)
-			continue
-		}
-		log.Printf("Patch %s saved successfully", currentPatch.Version)
+	archive, err := app.NewPageArchive(db, cfg)
+	if err != nil {
+		log.Fatalf("Error opening page archive: %v", err)
+	}
 
-		status, err := db.GetScrapingStatus()
+	filter := app.ReparseFilter{Patch: *patch, Champion: *champion, Role: *role}
+	if filter.Patch == "" {
+		status, err := db.GetScrapingStatus(ctx)
 		if err != nil {
-			log.Printf("Error getting scraping status: %v", err)
-			time.Sleep(1 * time.Hour)
-			continue
+			log.Fatalf("Error getting scraping status: %v", err)
 		}
-		log.Printf("Current scraping status: CurrentPatch=%s, LastScrapedPatch=%s, IsUpdating=%v",
-			status.CurrentPatch, status.LastScrapedPatch, status.IsUpdating)
-
-		if status.CurrentPatch == "" || currentPatch.Version != status.CurrentPatch || status.LastScrapedPatch == "" {
-			log.Printf("New patch detected or first run: %s", currentPatch.Version)
-
-			status.CurrentPatch = currentPatch.Version
-			status.IsUpdating = true
-			if err := db.UpdateScrapingStatus(status); err != nil {
-				log.Printf("Error updating scraping status: %v", err)
-				time.Sleep(1 * time.Hour)
-				continue
-			}
-			log.Println("Scraping status updated to indicate scraping in progress")
-
-			// Start scraping for the new patch
-			log.Println("Starting to scrape champions")
-			champions, err := ScrapeChampions()
-			if err != nil {
-				log.Printf("Error scraping champions: %v", err)
-				status.IsUpdating = false
-				if updateErr := db.UpdateScrapingStatus(status); updateErr != nil {
-					log.Printf("Error updating scraping status after champion scraping failure: %v", updateErr)
-				}
-				time.Sleep(1 * time.Hour)
-				continue
-			}
-			log.Printf("Scraped %d champions", len(champions))
-
-			for _, champ := range champions {
-				if err := db.SaveChampion(champ); err != nil {
-					log.Printf("Error saving champion %s: %v", champ.Name, err)
-					continue
-				}
-				log.Printf("Scraping matchups for %s", champ.Name)
-				matchups, err := ScrapeMatchups(champ.Name)
-				if err != nil {
-					log.Printf("Error scraping matchups for %s: %v", champ.Name, err)
-					continue
-				}
-				for role, roleMatchups := range matchups {
-					log.Printf("Saving %d matchups for %s in %s role", len(roleMatchups), champ.Name, role)
-					if err := db.SaveMatchups(champ.Name, role, roleMatchups, currentPatch.Version); err != nil {
-						log.Printf("Error saving matchups for %s in %s: %v", champ.Name, role, err)
-					}
-				}
-				log.Printf("Finished scraping matchups for %s", champ.Name)
-				time.Sleep(scrapingDelay)
-			}
-
-			log.Printf("Waiting for %v before serving new data", patchUpdateDelay)
-			time.Sleep(patchUpdateDelay)
-
-			status.LastScrapedPatch = currentPatch.Version
-			status.IsUpdating = false
-			if err := db.UpdateScrapingStatus(status); err != nil {
-				log.Printf("Error updating scraping status after completion: %v", err)
-			} else {
-				log.Println("Scraping status updated to indicate scraping completed")
-			}
-			log.Println("Scraping cycle completed")
-		} else {
-			log.Println("No new patch detected, skipping full scrape")
+		filter.Patch = status.LastScrapedPatch
+		if filter.Patch == "" {
+			log.Fatal("No patch is being served yet, pass -patch")
 		}
+	}
 
-		log.Printf("Sleeping for %v before next scraping cycle", scrapingInterval)
-		time.Sleep(scrapingInterval)
+	report, err := app.Reparse(ctx, db, archive, filter, *dryRun)
+	if err != nil {
+		log.Fatalf("Error reparsing archived pages: %v", err)
+	}
+	for _, failed := range report.Failed {
+		log.Printf("Could not reparse %s", failed)
+	}
+	action := "Rebuilt"
+	if report.DryRun {
+		action = "Would rebuild"
 	}
+	log.Printf("%s %d matchups and %d champions of patch %s from %d archived pages",
+		action, report.Matchups, report.Champions, report.Patch, report.Pages)
 }